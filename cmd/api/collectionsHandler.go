@@ -0,0 +1,379 @@
+// Filename: cmd/api/collectionsHandler.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+func (a *applicationDependencies) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	collection := &data.Collection{
+		UserID:      a.contextGetUser(r).ID,
+		Name:        data.NormalizeText(incomingData.Name),
+		Description: data.NormalizeText(incomingData.Description),
+		Public:      incomingData.Public,
+	}
+	v := validator.New()
+	data.ValidateCollection(v, collection)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = a.collectionModel.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollection):
+			a.duplicateCollectionResponse(w, r, v)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+	data := envelope{
+		"collection": collection,
+	}
+	err = a.writeJSON(w, r, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// anyone can see a public collection, a private one
+// can only be seen by its owner
+func (a *applicationDependencies) displayCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, false)
+	if !ok {
+		return
+	}
+	quotes, err := a.collectionModel.GetQuotes(collection.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	// quotes sent back to moderation stay in the collection,
+	// but are only shown again once they are approved
+	collection.Quotes = slices.DeleteFunc(quotes, func(quote *data.Quote) bool {
+		return quote.Status != data.QuoteStatusApproved
+	})
+	data := envelope{
+		"collection": collection,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the collections of the user
+func (a *applicationDependencies) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	var filters data.Filters
+
+	v := validator.New()
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "name")
+	filters.SortSafelist = []string{"id", "name", "created_at",
+		"-id", "-name", "-created_at"}
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	collections, metadata, err := a.collectionModel.GetAllForUser(user.ID, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"collections": collections,
+		"@metadata":   metadata,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// rename a collection, change its description or who can see it
+func (a *applicationDependencies) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	var incomingData struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if incomingData.Name != nil {
+		collection.Name = data.NormalizeText(*incomingData.Name)
+	}
+	if incomingData.Description != nil {
+		collection.Description = data.NormalizeText(*incomingData.Description)
+	}
+	if incomingData.Public != nil {
+		collection.Public = *incomingData.Public
+	}
+
+	v := validator.New()
+	data.ValidateCollection(v, collection)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = a.collectionModel.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollection):
+			a.duplicateCollectionResponse(w, r, v)
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"collection": collection,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+	err := a.collectionModel.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"message": "collection successfully deleted",
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// put a quote in a collection. ?position=N puts it at that position
+// (starting at 1), otherwise it goes at the end. A quote that is
+// already in the collection is moved
+func (a *applicationDependencies) addCollectionQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+	quoteID, err := a.readInt64Param(r, "quote_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	// only approved quotes can be added
+	quote, err := a.quoteModel.Get(r.Context(), quoteID)
+	if err == nil && quote.Status != data.QuoteStatusApproved {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// no position (0) means at the end
+	v := validator.New()
+	position := a.getSingleIntegerParameter(r.URL.Query(), "position", 0, v)
+	v.Check(position >= 0, "position", "must be a positive integer")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.collectionModel.AddQuote(collection.ID, quote.ID, &position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"message":  "quote added to the collection",
+		"position": position,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) removeCollectionQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+	quoteID, err := a.readInt64Param(r, "quote_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	err = a.collectionModel.RemoveQuote(collection.ID, quoteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"message": "quote removed from the collection",
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// put the quotes of a collection in a new order, the body
+// lists every quote of the collection in the order wanted
+func (a *applicationDependencies) reorderCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := a.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+	var incomingData struct {
+		QuoteIDs []int64 `json:"quote_ids"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	quotes, err := a.collectionModel.GetQuotes(collection.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	current := make([]int64, len(quotes))
+	for i, quote := range quotes {
+		current[i] = quote.ID
+	}
+	slices.Sort(current)
+	v := validator.New()
+	v.Check(slices.Equal(current, slices.Sorted(slices.Values(incomingData.QuoteIDs))), "quote_ids",
+		"must contain every quote of the collection exactly once")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.collectionModel.Reorder(collection.ID, incomingData.QuoteIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		// someone changed the collection in the meantime
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	quotes, err = a.collectionModel.GetQuotes(collection.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	collection.Quotes = quotes
+	data := envelope{
+		"collection": collection,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// get the collection of the :id parameter, sending back an error response
+// if the user may not see it (or change it, with owner set).
+// Collections the user may not see are reported as not found
+func (a *applicationDependencies) readCollection(w http.ResponseWriter, r *http.Request,
+	owner bool) (*data.Collection, bool) {
+
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	collection, err := a.collectionModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	user := a.contextGetUser(r)
+	isOwner := !user.IsAnonymous() && collection.UserID == user.ID
+	switch {
+	case isOwner:
+		return collection, true
+	case !collection.Public:
+		a.notFoundResponse(w, r)
+	case owner:
+		a.notPermittedResponse(w, r)
+	default:
+		return collection, true
+	}
+	return nil, false
+}
@@ -0,0 +1,78 @@
+// Filename: cmd/api/compression_test.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+)
+
+// a page of quotes, the size of a typical list response
+func benchmarkQuotes() envelope {
+	quotes := make([]*data.Quote, 20)
+	for i := range quotes {
+		quotes[i] = &data.Quote{
+			ID:        int64(i + 1),
+			Content:   fmt.Sprintf("The only way to do great work is to love what you do, number %d.", i+1),
+			Author:    "Steve Jobs",
+			Language:  "en",
+			Score:     4.2,
+			Votes:     17,
+			Status:    "approved",
+			CreatedAt: time.Now(),
+			Version:   1,
+		}
+	}
+	return envelope{"quotes": quotes, "metadata": data.Metadata{CurrentPage: 1, PageSize: 20, TotalRecords: 20}}
+}
+
+// compare the size and the cost of each way we can send the same response
+func BenchmarkWriteJSON(b *testing.B) {
+	a := &applicationDependencies{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	a.config.environment = "production"
+	a.config.compression.enabled = true
+	a.config.compression.minSize = 1024
+	body := benchmarkQuotes()
+	handler := a.compressResponse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := a.writeJSON(w, r, http.StatusOK, body, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}))
+
+	tests := []struct {
+		name           string
+		target         string
+		acceptEncoding string
+	}{
+		{"compact", "/v1/quotes", ""},
+		{"pretty", "/v1/quotes?pretty=true", ""},
+		{"gzip", "/v1/quotes", "gzip"},
+		{"deflate", "/v1/quotes", "deflate"},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var size int
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+				if tt.acceptEncoding != "" {
+					r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if got := w.Header().Get("Content-Encoding"); got != tt.acceptEncoding {
+					b.Fatalf("Content-Encoding = %q, want %q", got, tt.acceptEncoding)
+				}
+				size = w.Body.Len()
+			}
+			b.ReportMetric(float64(size), "body-bytes")
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Filename: cmd/api/config_test.go
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the same settings in each of the formats we read
+func TestReadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+port: 5000
+db:
+  dsn: postgres://qod@db/qod
+  max-idle-time: 5m
+cors:
+  trusted-origins:
+    - http://a.example
+    - http://b.example
+trace-sample-ratio: 0.25
+`,
+		"config.json": `{
+  "port": 5000,
+  "db": {"dsn": "postgres://qod@db/qod", "max-idle-time": "5m"},
+  "cors": {"trusted-origins": ["http://a.example", "http://b.example"]},
+  "trace-sample-ratio": 0.25
+}`,
+		"config.toml": `
+port = 5000
+trace-sample-ratio = 0.25
+
+[db]
+dsn = "postgres://qod@db/qod"
+max-idle-time = "5m"
+
+[cors]
+trusted-origins = ["http://a.example", "http://b.example"]
+`,
+	}
+	want := map[string]string{
+		"port":                 "5000",
+		"db-dsn":               "postgres://qod@db/qod",
+		"db-max-idle-time":     "5m",
+		"cors-trusted-origins": "http://a.example http://b.example",
+		"trace-sample-ratio":   "0.25",
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			err := os.WriteFile(path, []byte(content), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := readConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(values) != len(want) {
+				t.Errorf("got %d settings, want %d: %v", len(values), len(want), values)
+			}
+			for key, value := range want {
+				if values[key] != value {
+					t.Errorf("%s = %q, want %q", key, values[key], value)
+				}
+			}
+
+			settings, _, err := loadConfig([]string{"-config", path}, func(string) (string, bool) { return "", false })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.port != 5000 || settings.db.maxIdleTime != 5*time.Minute ||
+				len(settings.cors.trustedOrigins) != 2 || settings.tracing.sampleRatio != 0.25 {
+				t.Errorf("settings not loaded from %s: %+v", name, settings)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"config.ini", "port=5000", "unsupported config file type"},
+		{"config", "port: 5000", "unsupported config file type"},
+		{"broken.toml", "port = ", "broken.toml"},
+		{"broken.json", `{"port": `, "broken.json"},
+		{"broken.yaml", "port: [", "broken.yaml"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			err := os.WriteFile(path, []byte(tt.content), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = readConfigFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// the flags win over the environment, which wins over the file
+func TestLoadConfigLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte("port = 5000\nenv = \"staging\"\nlimiter-burst = 8\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"QOD_CONFIG": path, "QOD_ENV": "production", "QOD_PORT": "6000"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	settings, _, err := loadConfig([]string{"-port", "7000"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.port != 7000 || settings.environment != "production" || settings.limiter.burst != 8 ||
+		settings.limiter.rps != 2 {
+		t.Errorf("port = %d, env = %q, burst = %d, rps = %v; want 7000, production, 8, 2",
+			settings.port, settings.environment, settings.limiter.burst, settings.limiter.rps)
+	}
+}
@@ -0,0 +1,66 @@
+// Filename: cmd/api/context.go
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+)
+
+// use our own type for the context keys so that they can't
+// collide with keys set by other packages
+type contextKey string
+
+const requestIDContextKey = contextKey("requestID")
+const userContextKey = contextKey("user")
+const routeContextKey = contextKey("route")
+
+// add the request ID to the request's context
+func (a *applicationDependencies) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// get the request ID back, it is empty if the requestID middleware didn't run
+func (a *applicationDependencies) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// add the user making the request to the request's context
+func (a *applicationDependencies) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// get the user back. The authenticate middleware always sets one
+// (maybe the anonymous user), so if it is missing we messed up
+func (a *applicationDependencies) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// make room in the context for the route pattern of the request.
+// The logging and metrics middleware run before the router knows the
+// route, so they hand down a pointer that the route fills in once it
+// matched. They share the same one
+func (a *applicationDependencies) contextSetRoute(r *http.Request) (*http.Request, *string) {
+	if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+		return r, route
+	}
+	route := new(string)
+	ctx := context.WithValue(r.Context(), routeContextKey, route)
+	return r.WithContext(ctx), route
+}
+
+// record the pattern of the route that is handling the request
+func (a *applicationDependencies) contextSetRoutePattern(r *http.Request, pattern string) {
+	route, ok := r.Context().Value(routeContextKey).(*string)
+	if ok {
+		*route = pattern
+	}
+}
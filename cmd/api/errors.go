@@ -2,31 +2,132 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/i18n"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// the machine-readable codes of our error responses. Clients should
+// rely on these rather than on the wording of the messages
+const (
+	codeServerError            = "server_error"
+	codeNotFound               = "not_found"
+	codeMethodNotAllowed       = "method_not_allowed"
+	codeBadRequest             = "bad_request"
+	codeValidationFailed       = "validation_failed"
+	codeRateLimited            = "rate_limited"
+	codeEditConflict           = "edit_conflict"
+	codeNotAcceptable          = "not_acceptable"
+	codeInvalidCredentials     = "invalid_credentials"
+	codeInvalidToken           = "invalid_token"
+	codeAuthenticationRequired = "authentication_required"
+	codeForbidden              = "forbidden"
+	codeInactiveAccount        = "inactive_account"
+	codeDatabaseUnavailable    = "database_unavailable"
 )
 
+// every problem code, the title and detail of each of them
+// come from the message catalogs (errors_test.go checks they are there)
+var problemCodes = []string{
+	codeServerError, codeNotFound, codeMethodNotAllowed, codeBadRequest,
+	codeValidationFailed, codeRateLimited, codeEditConflict, codeNotAcceptable,
+	codeInvalidCredentials, codeInvalidToken, codeAuthenticationRequired, codeForbidden,
+	codeInactiveAccount, codeDatabaseUnavailable,
+}
+
+// a single validation failure in a problem
+type problemFieldError struct {
+	Field   string           `json:"field"`
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Params  validator.Params `json:"params,omitempty"`
+}
+
 // log an error message
 func (a *applicationDependencies) logError(r *http.Request, err error) {
 
 	method := r.Method
 	uri := r.URL.RequestURI()
-	a.logger.Error(err.Error(), "method", method, "uri", uri)
+	a.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 
 }
+
+// send an RFC 9457 application/problem+json response. The title and
+// detail are looked up in the catalog of the language the client asked
+// for in Accept-Language, the params fill in the blanks of the detail.
+// While clients migrate, the -errors-legacy flag brings back the old
+// {"error": ...} shape
 func (a *applicationDependencies) errorResponseJSON(w http.ResponseWriter,
 	r *http.Request,
 	status int,
-	message any) {
+	code string,
+	params map[string]any,
+	fieldErrors []problemFieldError) {
+
+	lang := i18n.Language(r.Header.Get("Accept-Language"))
+	title, _ := i18n.Translate(lang, "problem."+code+".title", nil)
+	detail, _ := i18n.Translate(lang, "problem."+code+".detail", params)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
 
-	errorData := envelope{"error": message}
-	err := a.writeJSON(w, status, errorData, nil)
+	var err error
+	if a.config.errors.legacy {
+		var message any = detail
+		if fieldErrors != nil {
+			messages := make(map[string]string)
+			for _, fieldError := range fieldErrors {
+				if _, exists := messages[fieldError.Field]; !exists {
+					messages[fieldError.Field] = fieldError.Message
+				}
+			}
+			message = messages
+		}
+		err = a.writeJSON(w, r, status, envelope{"error": message}, nil)
+	} else {
+		errorData := envelope{
+			"type":     "urn:qod:problem:" + code,
+			"title":    title,
+			"status":   status,
+			"detail":   detail,
+			"instance": r.URL.Path,
+			"code":     code,
+		}
+		if requestID := a.contextGetRequestID(r); requestID != "" {
+			errorData["request_id"] = requestID
+		}
+		if fieldErrors != nil {
+			errorData["errors"] = fieldErrors
+		}
+		headers := make(http.Header)
+		headers.Set("Content-Type", "application/problem+json")
+		err = a.writeJSON(w, r, status, errorData, headers)
+	}
 	if err != nil {
 		a.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// the message of a validation error in the given language. English keeps
+// the message of the check itself, which can be more specific than the
+// catalog's (e.g. for one-off checks)
+func localizeFieldError(lang string, fieldError validator.FieldError) string {
+	if lang == i18n.DefaultLanguage {
+		return fieldError.Message
+	}
+	message, found := i18n.Translate(lang, fieldError.Code, fieldError.Params)
+	if !found {
+		return fieldError.Message
+	}
+	return message
+}
+
 // send an error response if our server messes up
 func (a *applicationDependencies) serverErrorResponse(w http.ResponseWriter,
 	r *http.Request,
@@ -34,9 +135,21 @@ func (a *applicationDependencies) serverErrorResponse(w http.ResponseWriter,
 
 	// first thing is to log error message
 	a.logError(r, err)
+	// the database being down isn't something a retry right now will fix
+	if errors.Is(err, data.ErrDatabaseUnavailable) {
+		a.databaseUnavailableResponse(w, r)
+		return
+	}
 	// prepare a response to send to the client
-	message := "the server encountered a problem and could not process your request"
-	a.errorResponseJSON(w, r, http.StatusInternalServerError, message)
+	a.errorResponseJSON(w, r, http.StatusInternalServerError, codeServerError, nil, nil)
+}
+
+// send a 503 while the database can't be reached, telling the client
+// when the circuit breaker is going to try it again
+func (a *applicationDependencies) databaseUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	retryAfter := max(int(math.Ceil(a.dbBreaker.RetryAfter().Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	a.errorResponseJSON(w, r, http.StatusServiceUnavailable, codeDatabaseUnavailable, nil, nil)
 }
 
 // send an error response if our client messes up with a 404
@@ -45,8 +158,7 @@ func (a *applicationDependencies) notFoundResponse(w http.ResponseWriter,
 
 	// we only log server errors, not client errors
 	// prepare a response to send to the client
-	message := "the requested resource could not be found"
-	a.errorResponseJSON(w, r, http.StatusNotFound, message)
+	a.errorResponseJSON(w, r, http.StatusNotFound, codeNotFound, nil, nil)
 }
 
 // send an error response if our client messes up with a 405
@@ -56,9 +168,8 @@ func (a *applicationDependencies) methodNotAllowedResponse(
 
 	// we only log server errors, not client errors
 	// prepare a formatted response to send to the client
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-
-	a.errorResponseJSON(w, r, http.StatusMethodNotAllowed, message)
+	params := map[string]any{"method": r.Method}
+	a.errorResponseJSON(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, params, nil)
 }
 
 // send an error response if our client messes up with a 400 (bad request)
@@ -66,27 +177,123 @@ func (a *applicationDependencies) badRequestResponse(w http.ResponseWriter,
 	r *http.Request,
 	err error) {
 
-	message := fmt.Sprintf("the request is invalid: %v", err)
-	a.errorResponseJSON(w, r, http.StatusBadRequest, message)
+	params := map[string]any{"error": err}
+	a.errorResponseJSON(w, r, http.StatusBadRequest, codeBadRequest, params, nil)
 
 }
 
+// send an error response listing every error of every field that
+// failed validation (422)
 func (a *applicationDependencies) failedValidationResponse(w http.ResponseWriter, r *http.Request,
-	errors map[string]string) {
-	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
+	errors map[string][]validator.FieldError) {
+
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	lang := i18n.Language(r.Header.Get("Accept-Language"))
+	var fieldErrors []problemFieldError
+	for _, field := range fields {
+		for _, fieldError := range errors[field] {
+			fieldErrors = append(fieldErrors, problemFieldError{
+				Field:   field,
+				Code:    fieldError.Code,
+				Message: localizeFieldError(lang, fieldError),
+				Params:  fieldError.Params,
+			})
+		}
+	}
+	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, codeValidationFailed, nil, fieldErrors)
 }
 
 // send an error response if rate limit exceeded (429 - Too Many Requests)
 func (a *applicationDependencies) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
-	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+	a.errorResponseJSON(w, r, http.StatusTooManyRequests, codeRateLimited, nil, nil)
 }
 
 // send an error response if we have an edit conflict status 409
 func (a *applicationDependencies) editConflictResponse(w http.ResponseWriter,
 	r *http.Request) {
 
-	message := "unable to update the record due to an edit conflict, please try again"
-	a.errorResponseJSON(w, r, http.StatusConflict, message)
+	a.errorResponseJSON(w, r, http.StatusConflict, codeEditConflict, nil, nil)
+
+}
+
+// send an error response if we can't produce any of the formats
+// listed in the Accept header (406 - Not Acceptable)
+func (a *applicationDependencies) notAcceptableResponse(w http.ResponseWriter,
+	r *http.Request,
+	data envelope) {
+
+	params := map[string]any{"types": acceptableMediaTypes(data)}
+	a.errorResponseJSON(w, r, http.StatusNotAcceptable, codeNotAcceptable, params, nil)
+}
+
+// send a validation error if a quote would have two versions in
+// the same language
+func (a *applicationDependencies) duplicateTranslationResponse(w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator) {
+
+	v.AddFieldError("language", validator.FieldError{
+		Code:    validator.CodeAlreadyExists,
+		Message: "this quote already has a version in this language",
+	})
+	a.failedValidationResponse(w, r, v.Errors)
+}
+
+// send an error response if the email address or password is wrong (401)
+func (a *applicationDependencies) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, codeInvalidCredentials, nil, nil)
+}
+
+// send an error response if the bearer token is unknown or expired (401)
+func (a *applicationDependencies) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, codeInvalidToken, nil, nil)
+}
+
+// send an error response if an anonymous user tries to do
+// something that needs a user (401)
+func (a *applicationDependencies) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, codeAuthenticationRequired, nil, nil)
+}
+
+// send an error response if the user may not do this (403)
+func (a *applicationDependencies) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	a.errorResponseJSON(w, r, http.StatusForbidden, codeForbidden, nil, nil)
+}
+
+// send an error response if the user logging in hasn't activated their
+// account (403)
+func (a *applicationDependencies) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	a.errorResponseJSON(w, r, http.StatusForbidden, codeInactiveAccount, nil, nil)
+}
+
+// send a validation error if the user already has a collection
+// with the same name
+func (a *applicationDependencies) duplicateCollectionResponse(w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator) {
+
+	v.AddFieldError("name", validator.FieldError{
+		Code:    validator.CodeAlreadyExists,
+		Message: "you already have a collection with this name",
+	})
+	a.failedValidationResponse(w, r, v.Errors)
+}
+
+// send a validation error if the user already has an open
+// report on the quote
+func (a *applicationDependencies) duplicateReportResponse(w http.ResponseWriter,
+	r *http.Request,
+	v *validator.Validator) {
 
+	v.AddFieldError("quote_id", validator.FieldError{
+		Code:    validator.CodeAlreadyExists,
+		Message: "you have already reported this quote",
+	})
+	a.failedValidationResponse(w, r, v.Errors)
 }
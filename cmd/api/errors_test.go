@@ -0,0 +1,60 @@
+// Filename: cmd/api/errors_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/amilcar-vasquez/qod/internal/i18n"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// every message we may send back has to exist in every language: the
+// validation codes, and the title and detail of every problem
+func TestMessagesAreTranslated(t *testing.T) {
+	keys := slices.Clone(validator.Codes)
+	for _, code := range problemCodes {
+		keys = append(keys, "problem."+code+".title", "problem."+code+".detail")
+	}
+	for _, lang := range []string{"en", "es"} {
+		if !slices.Contains(i18n.Languages(), lang) {
+			t.Errorf("%s is not a supported language", lang)
+		}
+	}
+	for lang, missing := range i18n.Missing(keys) {
+		t.Errorf("%s has no message for %q", lang, missing)
+	}
+}
+
+func TestContentLanguage(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		headers        http.Header
+		want           string
+	}{
+		{"no preference", "", nil, "en"},
+		{"supported", "es-MX,es;q=0.9", nil, "es"},
+		{"unsupported", "fr", nil, "en"},
+		{"set by the handler", "es", http.Header{"Content-Language": {"fr"}}, "fr"},
+	}
+	a := &applicationDependencies{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/quotes/1", nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			err := a.writeJSON(w, r, http.StatusOK, envelope{"status": "ok"}, tt.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.want {
+				t.Errorf("Content-Language = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
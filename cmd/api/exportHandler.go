@@ -0,0 +1,191 @@
+// Filename: cmd/api/exportHandler.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// how many quotes we write before flushing them to the client
+const exportFlushEvery = 100
+
+// a quoteEncoder writes quotes to the response in one export format
+type quoteEncoder interface {
+	begin(exportedAt time.Time) error
+	encode(quote *data.Quote) error
+	end() error
+}
+
+func (a *applicationDependencies) exportQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	format := a.getSingleQueryParameter(queryParameters, "format", "json")
+
+	v := validator.New()
+	criteria := a.readQuoteCriteria(queryParameters, v)
+	v.OneOf("format", format, "csv", "ndjson", "json")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var encoder quoteEncoder
+	var contentType string
+	switch format {
+	case "csv":
+		encoder = &csvQuoteEncoder{w: csv.NewWriter(w)}
+		contentType = "text/csv; charset=utf-8"
+	case "ndjson":
+		encoder = &ndjsonQuoteEncoder{enc: json.NewEncoder(w)}
+		contentType = "application/x-ndjson"
+	default:
+		encoder = &jsonQuoteEncoder{w: w}
+		contentType = "application/json"
+	}
+
+	// an export can take a lot longer than the server's WriteTimeout
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// nothing is sent until the first quote arrives, so that an early
+	// failure can still be reported with a proper error response
+	exportedAt := time.Now().UTC()
+	started := false
+	start := func() error {
+		started = true
+		filename := fmt.Sprintf("qod-export-%s.%s", exportedAt.Format("20060102T150405Z"), format)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		return encoder.begin(exportedAt)
+	}
+
+	written := 0
+	err = a.quoteModel.Export(r.Context(), criteria, func(quote *data.Quote) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := encoder.encode(quote); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		// too late to tell the client, the truncated body will have to do
+		a.logError(r, err)
+		return
+	}
+	// no quote matched, we still send back an (empty) export
+	if !started {
+		err = start()
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+	if err != nil {
+		a.logError(r, err)
+	}
+}
+
+// a JSON document with the same envelope as the list endpoint
+type jsonQuoteEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonQuoteEncoder) begin(exportedAt time.Time) error {
+	_, err := fmt.Fprintf(e.w, "{\n\t\"exported_at\": %q,\n\t\"quotes\": [", exportedAt.Format(time.RFC3339))
+	return err
+}
+
+func (e *jsonQuoteEncoder) encode(quote *data.Quote) error {
+	js, err := json.Marshal(quote)
+	if err != nil {
+		return err
+	}
+	separator := ",\n\t\t"
+	if e.count == 0 {
+		separator = "\n\t\t"
+	}
+	e.count++
+	_, err = fmt.Fprintf(e.w, "%s%s", separator, js)
+	return err
+}
+
+func (e *jsonQuoteEncoder) end() error {
+	_, err := io.WriteString(e.w, "\n\t]\n}\n")
+	return err
+}
+
+// one JSON quote per line
+type ndjsonQuoteEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonQuoteEncoder) begin(exportedAt time.Time) error {
+	return nil
+}
+
+func (e *ndjsonQuoteEncoder) encode(quote *data.Quote) error {
+	return e.enc.Encode(quote)
+}
+
+func (e *ndjsonQuoteEncoder) end() error {
+	return nil
+}
+
+// a CSV file with a header, which can be fed back to the import endpoint
+type csvQuoteEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvQuoteEncoder) begin(exportedAt time.Time) error {
+	return e.w.Write([]string{"id", "content", "author", "language", "original_id", "version"})
+}
+
+func (e *csvQuoteEncoder) encode(quote *data.Quote) error {
+	originalID := ""
+	if quote.OriginalID != nil {
+		originalID = strconv.FormatInt(*quote.OriginalID, 10)
+	}
+	err := e.w.Write([]string{
+		strconv.FormatInt(quote.ID, 10),
+		quote.Content,
+		quote.Author,
+		quote.Language,
+		originalID,
+		strconv.Itoa(int(quote.Version)),
+	})
+	if err != nil {
+		return err
+	}
+	// the csv writer buffers, so push the row through before any flush
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvQuoteEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
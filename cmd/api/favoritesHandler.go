@@ -0,0 +1,125 @@
+// Filename: cmd/api/favoritesHandler.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// save a quote to the user's favorites. Saving it again does nothing
+func (a *applicationDependencies) addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	quoteID, err := a.readInt64Param(r, "quote_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	// only approved quotes can be saved
+	quote, err := a.quoteModel.Get(r.Context(), quoteID)
+	if err == nil && quote.Status != data.QuoteStatusApproved {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user := a.contextGetUser(r)
+	created, err := a.favoriteModel.Add(user.ID, quote.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	data := envelope{
+		"message": "quote saved to favorites",
+	}
+	err = a.writeJSON(w, r, status, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// take a quote out of the user's favorites
+func (a *applicationDependencies) removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	quoteID, err := a.readInt64Param(r, "quote_id")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	user := a.contextGetUser(r)
+	err = a.favoriteModel.Remove(user.ID, quoteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"message": "quote removed from favorites",
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the user's favorite quotes, the most recently saved first
+func (a *applicationDependencies) listFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		data.QuoteCriteria
+		data.Filters
+	}
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	queryParametersData.QuoteCriteria = a.readQuoteCriteria(queryParameters, v)
+	queryParametersData.Filters.Page = a.getSingleIntegerParameter(
+		queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(
+		queryParameters, "page_size", 10, v)
+	queryParametersData.Filters.Sort = a.getSingleQueryParameter(
+		queryParameters, "sort", "-favorited_at")
+
+	queryParametersData.Filters.SortSafelist = []string{"id", "author", "score", "votes", "favorited_at",
+		"-id", "-author", "-score", "-votes", "-favorited_at"}
+
+	data.ValidateFilters(v, queryParametersData.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	quotes, metadata, err := a.favoriteModel.GetAll(user.ID, queryParametersData.QuoteCriteria, queryParametersData.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"quotes":    quotes,
+		"@metadata": metadata,
+	}
+	err = a.render(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
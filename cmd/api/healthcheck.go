@@ -2,14 +2,108 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
+// the old endpoint, it says the same thing as live
 func (a *applicationDependencies) healthcheckHandler(w http.ResponseWriter,
 	r *http.Request) {
 	// panic("Apples & Oranges") // deliberate panic
 	data := envelope{"status": "available", "system_info": map[string]string{"environment": a.config.environment, "version": appVersion}}
-	err := a.writeJSON(w, http.StatusOK, data, nil)
+	err := a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// live only says the process is up and handling requests. It never
+// looks at the dependencies, restarting us won't fix the database
+func (a *applicationDependencies) liveHandler(w http.ResponseWriter, r *http.Request) {
+	a.healthcheckHandler(w, r)
+}
+
+// the result of checking one dependency
+type dependencyCheck struct {
+	Status  string `json:"status"` // up or down
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// the migrations check only
+	Version  *int64 `json:"version,omitempty"`
+	Expected *int64 `json:"expected,omitempty"`
+	// the replicas only
+	Lag string `json:"lag,omitempty"`
+}
+
+func newDependencyCheck(start time.Time, err error) dependencyCheck {
+	check := dependencyCheck{Status: "up", Latency: time.Since(start).String()}
+	if err != nil {
+		check.Status = "down"
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// ready says if we can take traffic: the database answers, every
+// migration of this build (and no other) has been applied and the background workers are working.
+// It also reports how far behind the primary each read replica is.
+// It sends back a 503 when we can't, and as soon as we start shutting
+// down, so the load balancer stops sending us requests
+func (a *applicationDependencies) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	checks := map[string]dependencyCheck{}
+
+	start := time.Now()
+	err := a.db.PingContext(ctx)
+	checks["database"] = newDependencyCheck(start, err)
+
+	start = time.Now()
+	expected := a.migrator.Latest()
+	version, err := a.migrator.Check(ctx)
+	check := newDependencyCheck(start, err)
+	check.Version, check.Expected = &version, &expected
+	checks["migrations"] = check
+
+	check = dependencyCheck{Status: "up"}
+	err = a.views.health()
+	if err != nil {
+		check = dependencyCheck{Status: "down", Error: "view counter: " + err.Error()}
+	}
+	checks["workers"] = check
+
+	ready := !a.shuttingDown.Load()
+	for _, check := range checks {
+		if check.Status != "up" {
+			ready = false
+		}
+	}
+	status, statusCode := "ready", http.StatusOK
+	if !ready {
+		status, statusCode = "not_ready", http.StatusServiceUnavailable
+	}
+	// a replica being down or behind doesn't stop us, the reads go to
+	// the primary, so the replicas don't count towards being ready
+	replicas := map[string]dependencyCheck{}
+	for _, replica := range a.replicas.Status() {
+		check := dependencyCheck{Status: "up", Lag: replica.Lag.String()}
+		if !replica.Healthy {
+			check.Status, check.Error = "down", replica.Err.Error()
+		}
+		replicas[replica.Name] = check
+	}
+
+	data := envelope{
+		"status":        status,
+		"shutting_down": a.shuttingDown.Load(),
+		"checks":        checks,
+	}
+	if len(replicas) > 0 {
+		data["replicas"] = replicas
+	}
+	err = a.writeJSON(w, r, statusCode, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/i18n"
 	"github.com/amilcar-vasquez/qod/internal/validator"
 	"github.com/julienschmidt/httprouter"
 	"io"
@@ -17,19 +19,29 @@ import (
 type envelope map[string]any
 
 func (a *applicationDependencies) writeJSON(w http.ResponseWriter,
+	r *http.Request,
 	status int, data envelope,
 	headers http.Header) error {
-	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	var jsResponse []byte
+	var err error
+	// indentation is only for humans, so it is opt-in
+	if a.prettyJSON(r) {
+		jsResponse, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		jsResponse, err = json.Marshal(data)
+	}
 	if err != nil {
 		return err
 	}
 	jsResponse = append(jsResponse, '\n')
+	// set content type header, unless it's a more specific JSON type
+	// passed in with the additional headers
+	w.Header().Set("Content-Type", "application/json")
 	// additional headers to be set
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	// set content type header
-	w.Header().Set("Content-Type", "application/json")
+	setContentLanguage(w, r)
 	// explicitly set the response status code
 	w.WriteHeader(status)
 	_, err = w.Write(jsResponse)
@@ -41,6 +53,27 @@ func (a *applicationDependencies) writeJSON(w http.ResponseWriter,
 
 }
 
+// say which language the response is in, the one negotiated from
+// Accept-Language, unless the handler (e.g. a quote in its own language)
+// or the error response already did
+func setContentLanguage(w http.ResponseWriter, r *http.Request) {
+	if w.Header().Get("Content-Language") != "" {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", i18n.Language(r.Header.Get("Accept-Language")))
+}
+
+// the client can ask for indented JSON with ?pretty=true (or turn it off
+// with ?pretty=false), by default we only indent during development
+func (a *applicationDependencies) prettyJSON(r *http.Request) bool {
+	pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	if err != nil {
+		return a.config.environment == "development"
+	}
+	return pretty
+}
+
 func (a *applicationDependencies) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	// what is the max size of the request body (250KB seems reasonable)
 	maxBytes := 256_000
@@ -100,12 +133,17 @@ func (a *applicationDependencies) readJSON(w http.ResponseWriter, r *http.Reques
 }
 
 func (a *applicationDependencies) readIDParam(r *http.Request) (int64, error) {
-	// use the httprouter package to get the value of the :id parameter
+	return a.readInt64Param(r, "id")
+}
+
+// read an id from a URL parameter other than :id, e.g. :quote_id
+func (a *applicationDependencies) readInt64Param(r *http.Request, name string) (int64, error) {
+	// use the httprouter package to get the value of the parameter
 	// from the request context
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -149,9 +187,29 @@ func (a *applicationDependencies) getSingleIntegerParameter(
 	// try to convert to an integer
 	intValue, err := strconv.Atoi(result)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddFieldError(key, validator.FieldError{
+			Code:    validator.CodeNotInteger,
+			Message: "must be an integer value",
+		})
 		return defaultValue
 	}
 
 	return intValue
 }
+
+// read the search filters that every endpoint returning
+// a set of quotes accepts
+func (a *applicationDependencies) readQuoteCriteria(queryParameters url.Values,
+	v *validator.Validator) data.QuoteCriteria {
+	criteria := data.QuoteCriteria{
+		Content:  a.getSingleQueryParameter(queryParameters, "content", ""),
+		Author:   a.getSingleQueryParameter(queryParameters, "author", ""),
+		Language: a.getSingleQueryParameter(queryParameters, "lang", ""),
+	}
+	if criteria.Language != "" {
+		v.CheckCode(data.ValidLanguageTag(criteria.Language), "lang",
+			validator.CodeInvalidFormat, "must be a valid BCP 47 language tag", nil)
+		criteria.Language = data.CanonicalLanguageTag(criteria.Language)
+	}
+	return criteria
+}
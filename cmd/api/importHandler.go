@@ -0,0 +1,259 @@
+// Filename: cmd/api/importHandler.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// imports are a lot bigger than a single quote (10MB)
+const maxImportBytes = 10_000_000
+
+// the outcome of importing a single row
+type importRow struct {
+	Line   int                               `json:"line"`
+	Status string                            `json:"status"` // created, duplicate, invalid, skipped or failed
+	ID     int64                             `json:"id,omitempty"`
+	Errors map[string][]validator.FieldError `json:"errors,omitempty"`
+	quote  *data.Quote
+}
+
+func (a *applicationDependencies) importQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	// atomic imports all rows or none of them, partial imports the valid ones
+	mode := a.getSingleQueryParameter(r.URL.Query(), "mode", "atomic")
+	v := validator.New()
+	v.OneOf("mode", mode, "atomic", "partial")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		a.badRequestResponse(w, r, errors.New("the Content-Type header must be text/csv or application/x-ndjson"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []*importRow
+	switch mediaType {
+	case "text/csv":
+		rows, err = a.readImportCSV(r.Body)
+	case "application/x-ndjson":
+		rows, err = a.readImportNDJSON(r.Body)
+	default:
+		a.badRequestResponse(w, r, errors.New("the Content-Type header must be text/csv or application/x-ndjson"))
+		return
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if len(rows) == 0 {
+		a.badRequestResponse(w, r, errors.New("the body must contain at least one quote"))
+		return
+	}
+
+	// validate every row the same way a single quote is validated
+	var valid []*importRow
+	for _, row := range rows {
+		if row.Status == "invalid" {
+			continue
+		}
+		v := validator.New()
+		data.NormalizeQuote(row.quote)
+		data.ValidateQuote(v, row.quote, a.config.quotes.limits)
+		if !v.IsEmpty() {
+			row.Status = "invalid"
+			row.Errors = v.Errors
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	status := http.StatusOK
+	if mode == "atomic" && len(valid) != len(rows) {
+		// nothing gets inserted, the report tells the client what to fix
+		status = http.StatusUnprocessableEntity
+		for _, row := range valid {
+			row.Status = "skipped"
+		}
+	} else if len(valid) > 0 {
+		quotes := make([]*data.Quote, len(valid))
+		for i, row := range valid {
+			quotes[i] = row.quote
+		}
+		results, err := a.quoteModel.InsertBatch(r.Context(), quotes, mode == "atomic")
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		for i, row := range valid {
+			switch {
+			case errors.Is(results[i], data.ErrDuplicateQuote):
+				row.Status = "duplicate"
+			case results[i] != nil:
+				// the rows around it may have been created, so the client
+				// still gets the report and can send the failed rows again
+				a.logError(r, fmt.Errorf("import line %d: %w", row.Line, results[i]))
+				row.Status = "failed"
+				row.Errors = invalidImportRow("could not be saved, please try again")
+			default:
+				row.Status = "created"
+				row.ID = row.quote.ID
+			}
+		}
+	}
+
+	summary := map[string]int{"created": 0, "duplicate": 0, "invalid": 0, "skipped": 0, "failed": 0}
+	for _, row := range rows {
+		summary[row.Status]++
+	}
+	data := envelope{
+		"import": map[string]any{
+			"mode":    mode,
+			"total":   len(rows),
+			"summary": summary,
+		},
+		"rows": rows,
+	}
+	err = a.writeJSON(w, r, status, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// read a CSV body. The first line may be a header naming the content,
+// author and (optional) language columns, otherwise content is expected
+// to come first
+func (a *applicationDependencies) readImportCSV(body io.Reader) ([]*importRow, error) {
+	reader := csv.NewReader(body)
+	// we check the number of fields ourselves so that a bad row
+	// is reported instead of aborting the whole import
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	contentColumn, authorColumn, languageColumn := 0, 1, -1
+	var rows []*importRow
+	first := true
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				return nil, fmt.Errorf("the body contains badly-formed CSV (at line %d)", parseError.Line)
+			}
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first {
+			first = false
+			header := map[string]int{}
+			for i, name := range record {
+				header[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			content, hasContent := header["content"]
+			author, hasAuthor := header["author"]
+			if hasContent && hasAuthor {
+				contentColumn, authorColumn = content, author
+				if language, ok := header["language"]; ok {
+					languageColumn = language
+				}
+				continue
+			}
+		}
+
+		row := &importRow{Line: line}
+		if contentColumn >= len(record) || authorColumn >= len(record) {
+			row.Status = "invalid"
+			row.Errors = invalidImportRow("must contain a content and an author column")
+		} else {
+			row.quote = &data.Quote{
+				Content:  record[contentColumn],
+				Author:   record[authorColumn],
+				Language: data.DefaultLanguage,
+			}
+			if languageColumn >= 0 && languageColumn < len(record) && record[languageColumn] != "" {
+				row.quote.Language = record[languageColumn]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// read a newline delimited JSON body, one quote object per line
+func (a *applicationDependencies) readImportNDJSON(body io.Reader) ([]*importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []*importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		// blank lines are allowed between records
+		if len(text) == 0 {
+			continue
+		}
+		var incomingData struct {
+			Content  string `json:"content"`
+			Author   string `json:"author"`
+			Language string `json:"language"`
+		}
+		row := &importRow{Line: line}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&incomingData)
+		if err == nil && dec.More() {
+			err = errors.New("the line must only contain a single JSON object")
+		}
+		if err != nil {
+			row.Status = "invalid"
+			row.Errors = invalidImportRow(fmt.Sprintf("must be a valid JSON object: %v", err))
+		} else {
+			row.quote = &data.Quote{
+				Content:  incomingData.Content,
+				Author:   incomingData.Author,
+				Language: incomingData.Language,
+			}
+			if row.quote.Language == "" {
+				row.quote.Language = data.DefaultLanguage
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("the body contains a line longer than 1MB (after line %d)", line)
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+// the errors of a row that couldn't even be read as a quote
+func invalidImportRow(message string) map[string][]validator.FieldError {
+	v := validator.New()
+	v.AddError("row", message)
+	return v.Errors
+}
@@ -0,0 +1,112 @@
+// Filename: cmd/api/metrics.go
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/metrics"
+)
+
+// the route label of requests that didn't match any route, so that
+// scanners trying random URLs don't make up a new series each
+const unmatchedRoute = "unmatched"
+
+// appMetrics are the metrics Prometheus scrapes from /metrics
+type appMetrics struct {
+	registry           *metrics.Registry
+	requests           *metrics.CounterVec
+	requestDuration    *metrics.HistogramVec
+	inFlight           *metrics.Gauge
+	rateLimitRejection *metrics.Counter
+}
+
+func newAppMetrics(db *sql.DB, breaker *data.CircuitBreaker, quoteCache *data.QuoteCache) *appMetrics {
+	registry := metrics.NewRegistry()
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("qod_http_requests_total",
+			"Number of HTTP requests handled.", "route", "method", "status"),
+		requestDuration: registry.NewHistogramVec("qod_http_request_duration_seconds",
+			"Time taken to handle HTTP requests.", metrics.DefaultBuckets, "route", "method", "status"),
+		inFlight: registry.NewGauge("qod_http_requests_in_flight",
+			"Number of HTTP requests being handled."),
+		rateLimitRejection: registry.NewCounter("qod_rate_limit_rejections_total",
+			"Number of requests turned away by the rate limiter."),
+	}
+
+	// the connection pool, read when scraped
+	stats := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	registry.NewGaugeFunc("qod_db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("qod_db_open_connections", "Number of open connections to the database, in use or idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("qod_db_in_use_connections", "Number of connections in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("qod_db_idle_connections", "Number of idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("qod_db_wait_count_total", "Number of times a connection had to be waited for.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("qod_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("qod_db_max_idle_closed_total", "Connections closed because of the idle connection limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("qod_db_max_idle_time_closed_total", "Connections closed because they were idle too long.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	registry.NewCounterFunc("qod_db_max_lifetime_closed_total", "Connections closed because they were open too long.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+	registry.NewGaugeFunc("qod_db_circuit_open", "Whether queries are failing fast because the database is unreachable.",
+		func() float64 {
+			if breaker.Open() {
+				return 1
+			}
+			return 0
+		})
+
+	// the quote cache, when there is one
+	if quoteCache != nil {
+		registry.NewCounterFunc("qod_quote_cache_hits_total", "Number of quote lookups answered from memory.",
+			func() float64 { return float64(quoteCache.Hits()) })
+		registry.NewCounterFunc("qod_quote_cache_misses_total", "Number of quote lookups that went to the database.",
+			func() float64 { return float64(quoteCache.Misses()) })
+		registry.NewGaugeFunc("qod_quote_cache_quotes", "Number of quotes kept in memory.",
+			func() float64 { return float64(quoteCache.Len()) })
+	}
+
+	registry.RegisterGoCollector()
+	return m
+}
+
+// count a request once it has been handled
+func (m *appMetrics) observe(route string, method string, status int, duration time.Duration) {
+	statusLabel := strconv.Itoa(status)
+	m.requests.With(route, method, statusLabel).Inc()
+	m.requestDuration.With(route, method, statusLabel).Observe(duration.Seconds())
+}
+
+// send the metrics in the Prometheus text format
+func (a *applicationDependencies) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := a.metrics.registry.Write(w)
+	if err != nil {
+		a.logError(r, err)
+	}
+}
+
+// the server for /metrics. It listens on its own address so that
+// the metrics can be kept away from the public
+func (a *applicationDependencies) metricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", a.metricsHandler)
+	return &http.Server{
+		Addr:         a.config.metrics.addr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,74 @@
+// Filename: cmd/api/metrics_test.go
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/tracing"
+)
+
+// the requests turned away before the router are counted under their
+// route, only the ones matching no route at all are unmatched
+func TestMetricsRouteLabel(t *testing.T) {
+	breaker := data.NewCircuitBreaker(1, time.Second)
+	db, err := data.OpenDB("postgres://qod@localhost/qod", breaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	a := &applicationDependencies{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: newAppMetrics(db, breaker, nil),
+		tracer:  tracing.NewTracer(nil, 0),
+	}
+	a.config.limiter.enabled = true
+	a.config.limiter.rps = 0.001
+	a.config.limiter.burst = 1
+	handler := a.routes()
+
+	tests := []struct {
+		method        string
+		target        string
+		authorization string
+		wantStatus    int
+		wantSeries    string
+	}{
+		{http.MethodPost, "/v1/tokens/authentication", "Basic abc", http.StatusUnauthorized,
+			`qod_http_requests_total{route="/v1/tokens/authentication",method="POST",status="401"} 1`},
+		{http.MethodGet, "/v1/quotes/42/translations", "", http.StatusTooManyRequests,
+			`qod_http_requests_total{route="/v1/quotes/:id/translations",method="GET",status="429"} 1`},
+		{http.MethodGet, "/v1/nowhere", "", http.StatusTooManyRequests,
+			`qod_http_requests_total{route="unmatched",method="GET",status="429"} 1`},
+		{"BREW", "/v1/nowhere", "", http.StatusTooManyRequests,
+			`qod_http_requests_total{route="unmatched",method="other",status="429"} 1`},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.target, w.Code, tt.wantStatus)
+		}
+	}
+
+	var out strings.Builder
+	err = a.metrics.registry.Write(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if !strings.Contains(out.String(), tt.wantSeries) {
+			t.Errorf("missing %s in\n%s", tt.wantSeries, out.String())
+		}
+	}
+}
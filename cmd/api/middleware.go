@@ -1,12 +1,23 @@
 package main
 
 import (
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/tracing"
+	"github.com/amilcar-vasquez/qod/internal/validator"
 	"golang.org/x/time/rate"
 )
 
@@ -73,6 +84,11 @@ func (a *applicationDependencies) rateLimit(next http.Handler) http.Handler {
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the load balancer checks on us often, from the same address
+		if strings.HasPrefix(r.URL.Path, "/v1/healthcheck") {
+			next.ServeHTTP(w, r)
+			return
+		}
 		// get the client's IP address
 		if a.config.limiter.enabled {
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -91,6 +107,7 @@ func (a *applicationDependencies) rateLimit(next http.Handler) http.Handler {
 			// Check the rate limit status
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock() // no longer need exclusive access to the map
+				a.metrics.rateLimitRejection.Inc()
 				a.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -100,3 +117,482 @@ func (a *applicationDependencies) rateLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// readYourWrites sends the reads of a client that has just written
+// something to the primary, for as long as a replica may be behind
+// (-db-replica-max-lag), so that e.g. the quote at the Location of a
+// 201 is there when the client follows it. The clients are remembered
+// by this process only, like the rate limiter does
+func (a *applicationDependencies) readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.config.db.replicaDSNs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the authenticated users are known by who they are, the others by address
+		client, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if user := a.contextGetUser(r); !user.IsAnonymous() {
+			client = "user:" + strconv.FormatInt(user.ID, 10)
+		}
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+
+		if write || a.writers.recent(client) {
+			r = r.WithContext(data.WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+
+		if write {
+			a.writers.add(client)
+		}
+	})
+}
+
+// compressResponse gzips (or deflates) the response body when the client
+// says it can handle it in Accept-Encoding. Small bodies aren't worth the
+// trouble, so nothing is compressed until the body reaches the minimum size.
+func (a *applicationDependencies) compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// caches must not hand a compressed body to a client that can't read it
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        a.config.compression.minSize,
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(cw, r)
+		err := cw.Close()
+		if err != nil {
+			a.logError(r, err)
+		}
+	})
+}
+
+// pick gzip or deflate from an Accept-Encoding header, preferring gzip
+// when both are equally welcome. An empty result means no compression.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(key) == "q" {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+		qualities[coding] = q
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, found := qualities[coding]
+		if !found {
+			q, found = qualities["*"]
+		}
+		if found && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+var flateWriters = sync.Pool{New: func() any {
+	fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+	return fw
+}}
+
+// a compressWriter holds back the status and the first bytes of the body
+// until it knows whether the body is big enough to be compressed
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	minSize     int
+	status      int
+	wroteHeader bool // WriteHeader has been called by the handler
+	started     bool // the headers have been sent to the client
+	buf         []byte
+	compressor  io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	// informational responses go straight through
+	if status < 200 {
+		cw.wroteHeader = false
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if !cw.started {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// send the headers and whatever we have buffered so far, compressing
+// from here on if asked to and if the response allows it
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || cw.status == http.StatusNoContent ||
+		cw.status == http.StatusNotModified || !compressible(h.Get("Content-Type")) {
+		compress = false
+	}
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case "gzip":
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.compressor = gw
+		case "deflate":
+			fw := flateWriters.Get().(*flate.Writer)
+			fw.Reset(cw.ResponseWriter)
+			cw.compressor = fw
+		}
+	} else if cw.buf != nil {
+		h.Set("Content-Length", strconv.Itoa(len(cw.buf)))
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush is used by streaming handlers (e.g. the export), so whatever
+// has been written so far must reach the client, compressed or not
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+func (cw *compressWriter) FlushError() error {
+	if !cw.started {
+		if err := cw.start(true); err != nil {
+			return err
+		}
+	}
+	switch compressor := cw.compressor.(type) {
+	case *gzip.Writer:
+		if err := compressor.Flush(); err != nil {
+			return err
+		}
+	case *flate.Writer:
+		if err := compressor.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the response once the handler is done with it
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		// the body never reached the minimum size
+		if !cw.wroteHeader {
+			return nil
+		}
+		return cw.start(false)
+	}
+	if cw.compressor == nil {
+		return nil
+	}
+	err := cw.compressor.Close()
+	switch compressor := cw.compressor.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(compressor)
+	case *flate.Writer:
+		flateWriters.Put(compressor)
+	}
+	cw.compressor = nil
+	return err
+}
+
+// already compressed formats only get bigger when compressed again
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch {
+	case mediaType == "":
+		return true
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "json"), strings.HasSuffix(mediaType, "xml"),
+		strings.HasSuffix(mediaType, "yaml"):
+		return true
+	default:
+		return false
+	}
+}
+
+// metrics counts the requests and times them, by the pattern of the
+// route that handled them (not the raw URL, which would give a series
+// per quote), the method and the status sent back
+func (a *applicationDependencies) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		a.metrics.inFlight.Inc()
+		defer a.metrics.inFlight.Dec()
+
+		r, route := a.contextSetRoute(r)
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+		a.metrics.observe(routeLabel(*route), methodLabel(r.Method), rec.status, time.Since(start))
+	})
+}
+
+// the method is whatever the client sent, so anything but the standard
+// methods is put together, as with routeLabel
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// trace starts the span of a request, continuing the trace of the
+// client when it sent a traceparent header. The trace context goes back
+// out in the response headers, so a client can find its trace
+func (a *applicationDependencies) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent := tracing.Extract(r.Header)
+		ctx, span := a.tracer.Start(r.Context(), "HTTP "+r.Method, tracing.KindServer, parent,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("http.request.id", a.contextGetRequestID(r)))
+		defer span.End()
+		tracing.Inject(ctx, w.Header())
+
+		r, route := a.contextSetRoute(r.WithContext(ctx))
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		span.SetName(r.Method + " " + routeLabel(*route))
+		span.SetAttributes(
+			tracing.String("http.route", routeLabel(*route)),
+			tracing.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.RecordError(errors.New(http.StatusText(rec.status)))
+		}
+	})
+}
+
+// logRequest writes one access log line per request
+func (a *applicationDependencies) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, route := a.contextSetRoute(r)
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		a.logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"route", routeLabel(*route),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"client_ip", ip)
+	})
+}
+
+// requests that don't match any route (not found, method not allowed)
+// are all put together
+func routeLabel(route string) string {
+	if route == "" {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// a responseRecorder remembers the status sent back to the client and
+// how many bytes of body went with it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// a handler that writes nothing sends back a 200
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	// informational responses are followed by the real one
+	if status >= 200 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// requestID gives every request an ID that is sent back to the client in
+// the X-Request-ID header, so that a client report can be matched with
+// what happened on our side. A client (or a proxy in front of us) can send
+// its own ID in X-Request-ID to follow a request across services
+func (a *applicationDependencies) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			id := make([]byte, 16)
+			_, err := rand.Read(id)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(id)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, a.contextSetRequestID(r, requestID))
+	})
+}
+
+// the IDs we take from clients end up in our logs, so they are kept
+// short and to characters that can't mess those up
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// find out who is making the request from the bearer token in the
+// Authorization header. Requests without one are made by the anonymous user
+func (a *applicationDependencies) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on who is asking
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, found := strings.Cut(authorizationHeader, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, token)
+		if !v.IsEmpty() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.userModel.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// only let authenticated users through to the handler
+func (a *applicationDependencies) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// only let users with a permission through to the handler
+func (a *applicationDependencies) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		permissions, err := a.permissionModel.GetAllForUser(user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
@@ -0,0 +1,74 @@
+// Filename: cmd/api/migrate.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/migrator"
+)
+
+const migrateUsage = `usage: qod [flags] migrate up | down [N] | status | goto N`
+
+// run the migrate subcommand, e.g. qod -db-dsn=... migrate up
+func runMigrate(out io.Writer, m *migrator.Migrator, args []string) error {
+	// migrations can take a while, but shouldn't hang forever
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		return m.Up(ctx)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down: %q is not a positive number of steps", args[1])
+			}
+			steps = n
+		}
+		return m.Down(ctx, steps)
+	case args[0] == "goto" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("goto: %q is not a version", args[1])
+		}
+		return m.Goto(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return writeMigrationStatus(out, statuses)
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func writeMigrationStatus(out io.Writer, statuses []migrator.Status) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT\tNOTE")
+	for _, status := range statuses {
+		appliedAt, note := "pending", ""
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		switch {
+		case status.ChangedFrom != "":
+			note = "changed since it was applied"
+		case status.Up == "" && status.Applied:
+			note = "unknown to this build"
+		}
+		fmt.Fprintf(tw, "%06d\t%s\t%s\t%s\n", status.Version, status.Name, appliedAt, note)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,199 @@
+// Filename: cmd/api/moderationHandler.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// the quotes waiting for a moderator, the oldest first
+func (a *applicationDependencies) moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	var filters data.Filters
+
+	v := validator.New()
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 10, v)
+	filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	filters.SortSafelist = []string{"id", "-id"}
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quotes, metadata, err := a.moderationModel.GetQueue(filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"quotes":    data.ModeratedQuotes(quotes),
+		"@metadata": metadata,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// let a quote into the curated set
+func (a *applicationDependencies) approveQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateQuote(w, r, data.ModerationApprove)
+}
+
+// keep a quote out of the curated set, the body has the reason
+func (a *applicationDependencies) rejectQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateQuote(w, r, data.ModerationReject)
+}
+
+func (a *applicationDependencies) moderateQuote(w http.ResponseWriter, r *http.Request, action string) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	quote, err := a.quoteModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the reason is optional when approving, so is the body
+	var incomingData struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		err = a.readJSON(w, r, &incomingData)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	reason := data.NormalizeText(incomingData.Reason)
+	v := validator.New()
+	data.ValidateModerationReason(v, action, reason)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	err = a.moderationModel.Decide(quote, user.ID, action, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"quote": quote.Moderated(),
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// what the moderators did, the latest first. ?quote_id= narrows
+// it down to a single quote
+func (a *applicationDependencies) moderationLogHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	var filters data.Filters
+
+	v := validator.New()
+	quoteID := a.getSingleIntegerParameter(queryParameters, "quote_id", 0, v)
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 20, v)
+	// the log is always in the order things happened
+	filters.Sort = "-id"
+	filters.SortSafelist = []string{"-id"}
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := a.moderationModel.GetLog(int64(quoteID), filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"entries":   entries,
+		"@metadata": metadata,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// check if the user of the request may moderate quotes
+func (a *applicationDependencies) isModerator(r *http.Request) (bool, error) {
+	user := a.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	permissions, err := a.permissionModel.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(data.PermissionModerateQuotes), nil
+}
+
+// everyone sees the approved quotes. The others are only seen
+// by the moderators and by the user who submitted them
+func (a *applicationDependencies) canSeeQuote(r *http.Request, quote *data.Quote) (bool, error) {
+	if quote.Status == data.QuoteStatusApproved {
+		return true, nil
+	}
+	user := a.contextGetUser(r)
+	if !user.IsAnonymous() && quote.SubmittedBy != nil && *quote.SubmittedBy == user.ID {
+		return true, nil
+	}
+	return a.isModerator(r)
+}
+
+// get the quote of the :id parameter, sending back a 404 if it
+// doesn't exist or the user may not see it
+func (a *applicationDependencies) readVisibleQuote(w http.ResponseWriter, r *http.Request) (*data.Quote, bool) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	quote, err := a.quoteModel.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	visible, err := a.canSeeQuote(r, quote)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !visible {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	return quote, true
+}
@@ -0,0 +1,77 @@
+// Filename: cmd/api/quoteCache.go
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/lib/pq"
+)
+
+// listen for the quotes changing, in this process or in any other, and
+// drop them from the quote cache, until ctx is done. The cache is only
+// used while we are listening
+func (a *applicationDependencies) listenForQuoteChanges(ctx context.Context) {
+	cache := a.quoteModel.Cache
+	if cache == nil {
+		return
+	}
+	listener := pq.NewListener(a.config.db.dsn, time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			switch event {
+			case pq.ListenerEventDisconnected:
+				cache.SetListening(false)
+				a.logger.Warn("not listening for quote changes, the quote cache is off", "error", err)
+			case pq.ListenerEventConnectionAttemptFailed:
+				a.logger.Warn("unable to listen for quote changes", "error", err)
+			}
+		})
+	defer listener.Close()
+	defer cache.SetListening(false)
+
+	// Listen waits for the listener to connect, which it keeps trying to
+	// do in the background. Closing the listener stops the wait
+	listened := make(chan error, 1)
+	go func() {
+		listened <- listener.Listen(data.QuoteChangesChannel)
+	}()
+	select {
+	case <-ctx.Done():
+		return
+	case err := <-listened:
+		if err != nil {
+			a.logger.Error("unable to listen for quote changes, the quote cache is off", "error", err)
+			return
+		}
+	}
+	cache.SetListening(true)
+	a.logger.Info("listening for quote changes", "channel", data.QuoteChangesChannel)
+
+	// a connection that died quietly is only noticed when used. The
+	// ticker keeps going however many notifications come in
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-listener.Notify:
+			// we reconnected, the changes made in the meantime are lost
+			if notification == nil {
+				cache.SetListening(true)
+				a.logger.Info("listening for quote changes again")
+				continue
+			}
+			id, err := strconv.ParseInt(notification.Extra, 10, 64)
+			if err != nil {
+				a.logger.Warn("unexpected quote change notification", "payload", notification.Extra)
+				continue
+			}
+			cache.Invalidate(id)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
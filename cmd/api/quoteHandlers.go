@@ -2,19 +2,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	// import the data package which contains the definition for Quote
 	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/i18n"
 	"github.com/amilcar-vasquez/qod/internal/validator"
 )
 
 func (a *applicationDependencies) createQuoteHandler(w http.ResponseWriter, r *http.Request) {
 	// create a struct to hold a quote
 	var incomingData struct {
-		Content string `json:"content"`
-		Author  string `json:"author"`
+		Content    string `json:"content"`
+		Author     string `json:"author"`
+		Language   string `json:"language"`
+		OriginalID *int64 `json:"original_id"`
 	}
 
 	// perform the decoding
@@ -25,21 +31,65 @@ func (a *applicationDependencies) createQuoteHandler(w http.ResponseWriter, r *h
 	}
 	// Copy the values from incomingData to a new Quote struct
 	quote := &data.Quote{
-		Content: incomingData.Content,
-		Author:  incomingData.Author,
+		Content:  incomingData.Content,
+		Author:   incomingData.Author,
+		Language: incomingData.Language,
+	}
+	if quote.Language == "" {
+		quote.Language = data.DefaultLanguage
+	}
+	// quotes suggested by anyone but a moderator wait in the
+	// moderation queue before they are shown
+	moderator, err := a.isModerator(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	quote.Status = data.QuoteStatusApproved
+	if !moderator {
+		quote.Status = data.QuoteStatusPending
+		if user := a.contextGetUser(r); !user.IsAnonymous() {
+			quote.SubmittedBy = &user.ID
+		}
 	}
 	// Initialize a Validator instance
 	v := validator.New()
+	// a translation always points to the original quote, even when
+	// it was translated from another translation
+	if incomingData.OriginalID != nil {
+		original, err := a.quoteModel.Get(r.Context(), *incomingData.OriginalID)
+		if err == nil && original.Status != data.QuoteStatusApproved {
+			err = data.ErrRecordNotFound
+		}
+		switch {
+		case err == nil:
+			quote.OriginalID = &original.ID
+			if original.OriginalID != nil {
+				quote.OriginalID = original.OriginalID
+			}
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("original_id", "must refer to an existing, approved quote")
+		default:
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 	// Use the validation function to check the quote data
-	data.ValidateQuote(v, quote)
+	data.NormalizeQuote(quote)
+	data.ValidateQuote(v, quote, a.config.quotes.limits)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	// Add the quote to the database table
-	err = a.quoteModel.Insert(quote)
+	err = a.quoteModel.Insert(r.Context(), quote)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateTranslation):
+			a.duplicateTranslationResponse(w, r, v)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -48,7 +98,7 @@ func (a *applicationDependencies) createQuoteHandler(w http.ResponseWriter, r *h
 	data := envelope{
 		"quote": quote,
 	}
-	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	err = a.writeJSON(w, r, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -56,25 +106,15 @@ func (a *applicationDependencies) createQuoteHandler(w http.ResponseWriter, r *h
 }
 
 func (a *applicationDependencies) displayQuoteHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := a.readIDParam(r)
-	if err != nil {
-		a.notFoundResponse(w, r)
-		return
-	}
-	quote, err := a.quoteModel.Get(id)
-	if err != nil {
-		switch {
-		case err == data.ErrRecordNotFound:
-			a.notFoundResponse(w, r)
-		default:
-			a.serverErrorResponse(w, r, err)
-		}
+	quote, ok := a.readVisibleQuote(w, r)
+	if !ok {
 		return
 	}
+	a.views.add(quote)
 	data := envelope{
 		"quote": quote,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err := a.render(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -88,7 +128,7 @@ func (a *applicationDependencies) updateQuoteHandler(w http.ResponseWriter, r *h
 		a.notFoundResponse(w, r)
 		return
 	}
-	quote, err := a.quoteModel.Get(id)
+	quote, err := a.quoteModel.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case err == data.ErrRecordNotFound:
@@ -100,8 +140,9 @@ func (a *applicationDependencies) updateQuoteHandler(w http.ResponseWriter, r *h
 	}
 
 	var incomingData struct {
-		Content *string `json:"content"`
-		Author  *string `json:"author"`
+		Content  *string `json:"content"`
+		Author   *string `json:"author"`
+		Language *string `json:"language"`
 	}
 
 	err = a.readJSON(w, r, &incomingData)
@@ -116,23 +157,36 @@ func (a *applicationDependencies) updateQuoteHandler(w http.ResponseWriter, r *h
 	if incomingData.Author != nil {
 		quote.Author = *incomingData.Author
 	}
+	if incomingData.Language != nil {
+		quote.Language = *incomingData.Language
+	}
 
 	v := validator.New()
-	data.ValidateQuote(v, quote)
+	data.NormalizeQuote(quote)
+	data.ValidateQuote(v, quote, a.config.quotes.limits)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = a.quoteModel.Update(quote)
+	err = a.quoteModel.Update(r.Context(), quote, a.contextGetUser(r).ID)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateTranslation):
+			a.duplicateTranslationResponse(w, r, v)
+		case errors.Is(err, data.ErrRecordNotFound):
+			// deleted since we read it
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+	// only moderators may edit quotes
 	data := envelope{
-		"quote": quote,
+		"quote": quote.Moderated(),
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -146,7 +200,7 @@ func (a *applicationDependencies) deleteQuoteHandler(w http.ResponseWriter, r *h
 		a.notFoundResponse(w, r)
 		return
 	}
-	err = a.quoteModel.Delete(id)
+	err = a.quoteModel.Delete(r.Context(), id, a.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case err == data.ErrRecordNotFound:
@@ -159,7 +213,7 @@ func (a *applicationDependencies) deleteQuoteHandler(w http.ResponseWriter, r *h
 	data := envelope{
 		"message": "quote successfully deleted",
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -168,23 +222,13 @@ func (a *applicationDependencies) deleteQuoteHandler(w http.ResponseWriter, r *h
 // list quotes handler
 func (a *applicationDependencies) listQuotesHandler(w http.ResponseWriter, r *http.Request) {
 	var queryParametersData struct {
-		Content string
-		Author  string
+		data.QuoteCriteria
 		data.Filters
 	}
 	queryParameters := r.URL.Query()
 
-	queryParametersData.Content = a.getSingleQueryParameter(
-		queryParameters,
-		"content",
-		"")
-
-	queryParametersData.Author = a.getSingleQueryParameter(
-		queryParameters,
-		"author",
-		"")
-
 	v := validator.New()
+	queryParametersData.QuoteCriteria = a.readQuoteCriteria(queryParameters, v)
 	queryParametersData.Filters.Page = a.getSingleIntegerParameter(
 		queryParameters, "page", 1, v)
 	queryParametersData.Filters.PageSize = a.getSingleIntegerParameter(
@@ -192,8 +236,8 @@ func (a *applicationDependencies) listQuotesHandler(w http.ResponseWriter, r *ht
 	queryParametersData.Filters.Sort = a.getSingleQueryParameter(
 		queryParameters, "sort", "id")
 
-	queryParametersData.Filters.SortSafelist = []string{"id", "author",
-		"-id", "-author"}
+	queryParametersData.Filters.SortSafelist = []string{"id", "author", "score", "votes",
+		"-id", "-author", "-score", "-votes"}
 
 	data.ValidateFilters(v, queryParametersData.Filters)
 	if !v.IsEmpty() {
@@ -201,7 +245,7 @@ func (a *applicationDependencies) listQuotesHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	quotes, metadata, err := a.quoteModel.GetAll(queryParametersData.Content, queryParametersData.Author, queryParametersData.Filters)
+	quotes, metadata, err := a.quoteModel.GetAll(r.Context(), queryParametersData.QuoteCriteria, queryParametersData.Filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -210,7 +254,160 @@ func (a *applicationDependencies) listQuotesHandler(w http.ResponseWriter, r *ht
 		"quotes":    quotes,
 		"@metadata": metadata,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.render(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// random quotes handler
+func (a *applicationDependencies) randomQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	criteria := a.readQuoteCriteria(queryParameters, v)
+	count := a.getSingleIntegerParameter(queryParameters, "count", 1, v)
+	v.Range("count", count, 1, 50)
+	// the seed is optional, without it every call gives different quotes
+	var seed *uint64
+	if queryParameters.Has("seed") {
+		value, err := strconv.ParseUint(queryParameters.Get("seed"), 10, 64)
+		if err != nil {
+			v.AddFieldError("seed", validator.FieldError{
+				Code:    validator.CodeNotInteger,
+				Message: "must be a non-negative integer value",
+			})
+		} else {
+			seed = &value
+		}
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quotes, err := a.quoteModel.GetRandom(r.Context(), criteria, count, seed)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.views.add(quotes...)
+	data := envelope{
+		"quotes": quotes,
+	}
+	if seed != nil {
+		data["seed"] = *seed
+	}
+	err = a.render(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// the quote of the day, in the language the client prefers
+// if we have a translation in that language
+func (a *applicationDependencies) dailyQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	quote, err := a.quoteModel.GetDaily(r.Context(), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if acceptLanguage := r.Header.Get("Accept-Language"); acceptLanguage != "" {
+		translations, err := a.quoteModel.GetTranslations(r.Context(), quote.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		versions := append([]*data.Quote{quote}, translations...)
+		languages := make([]string, len(versions))
+		for i, version := range versions {
+			languages[i] = version.Language
+		}
+		if best, ok := i18n.Best(acceptLanguage, languages); ok {
+			quote = versions[best]
+		}
+	}
+
+	a.views.add(quote)
+	headers := make(http.Header)
+	headers.Set("Content-Language", quote.Language)
+	headers.Set("Vary", "Accept-Language")
+	data := envelope{
+		"quote": quote,
+	}
+	err = a.render(w, r, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// list the other language versions of a quote
+func (a *applicationDependencies) listTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	quote, ok := a.readVisibleQuote(w, r)
+	if !ok {
+		return
+	}
+	translations, err := a.quoteModel.GetTranslations(r.Context(), quote.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"quote":        quote,
+		"translations": translations,
+	}
+	err = a.render(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// the trending windows, and how quickly views lose their weight in each
+var trendingWindows = map[string]struct {
+	length   time.Duration
+	halfLife time.Duration
+}{
+	"24h": {24 * time.Hour, 6 * time.Hour},
+	"7d":  {7 * 24 * time.Hour, 24 * time.Hour},
+	"30d": {30 * 24 * time.Hour, 7 * 24 * time.Hour},
+}
+
+// the quotes read the most lately, ?window=24h|7d|30d
+func (a *applicationDependencies) trendingQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	v := validator.New()
+	criteria := a.readQuoteCriteria(queryParameters, v)
+	window := a.getSingleQueryParameter(queryParameters, "window", "24h")
+	v.OneOf("window", window, "24h", "7d", "30d")
+	count := a.getSingleIntegerParameter(queryParameters, "count", 10, v)
+	v.Range("count", count, 1, 50)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	quotes, err := a.viewModel.GetTrending(criteria, trendingWindows[window].length,
+		trendingWindows[window].halfLife, count)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"quotes": quotes,
+		"window": window,
+	}
+	err = a.render(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,554 @@
+// Filename: cmd/api/render.go
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a format the render layer knows how to produce
+type renderFormat struct {
+	mediaType string
+	aliases   []string // other media types clients use for the same format
+	// reports if the format can represent this data at all
+	supports func(data envelope) bool
+	render   func(data envelope) ([]byte, error)
+}
+
+// the formats we can send back, in order of preference when the
+// client likes more than one of them equally
+var renderFormats = []renderFormat{
+	{
+		// rendered by writeJSON
+		mediaType: "application/json",
+		supports:  func(data envelope) bool { return true },
+	},
+	{
+		mediaType: "application/xml",
+		aliases:   []string{"text/xml"},
+		supports:  func(data envelope) bool { return true },
+		render:    renderXML,
+	},
+	{
+		mediaType: "text/csv",
+		supports:  func(data envelope) bool { _, ok := listOf(data); return ok },
+		render:    renderCSV,
+	},
+	{
+		mediaType: "application/yaml",
+		aliases:   []string{"application/x-yaml", "text/yaml"},
+		supports:  func(data envelope) bool { return true },
+		render:    renderYAML,
+	},
+	{
+		mediaType: "text/plain",
+		supports:  hasQuotes,
+		render:    renderFortune,
+	},
+}
+
+// render sends the envelope back in the format the client asked for in
+// its Accept header, defaulting to JSON. If none of the acceptable
+// formats can represent the data, the client gets a 406 instead.
+func (a *applicationDependencies) render(w http.ResponseWriter, r *http.Request,
+	status int, data envelope, headers http.Header) error {
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiateFormat(r.Header.Get("Accept"), data)
+	if !ok {
+		a.notAcceptableResponse(w, r, data)
+		return nil
+	}
+	// JSON goes through writeJSON so that it looks the same everywhere
+	if format.mediaType == "application/json" {
+		return a.writeJSON(w, r, status, data, headers)
+	}
+
+	body, err := format.render(data)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	setContentLanguage(w, r)
+	w.Header().Set("Content-Type", format.mediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return err
+}
+
+// the media types that could be used to represent the data
+func acceptableMediaTypes(data envelope) []string {
+	var mediaTypes []string
+	for _, format := range renderFormats {
+		if format.supports(data) {
+			mediaTypes = append(mediaTypes, format.mediaType)
+		}
+	}
+	return mediaTypes
+}
+
+// pick the format the client prefers (highest q value) among the ones
+// that can represent the data. A missing Accept header means JSON, and
+// so does one with */* in it: browsers send e.g.
+// text/html,application/xml;q=0.9,*/*;q=0.8 whatever they are fetching,
+// the types they list are the ones they can display, not what they want
+// from an API.
+func negotiateFormat(accept string, data envelope) (renderFormat, bool) {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return renderFormats[0], true
+	}
+	for _, rng := range ranges {
+		// unless JSON was ruled out with application/json;q=0
+		if rng.mediaType == "*/*" && rng.q > 0 && acceptQuality(ranges, renderFormats[0].mediaType) > 0 {
+			return renderFormats[0], true
+		}
+	}
+
+	best, bestQ := -1, 0.0
+	for i, format := range renderFormats {
+		if !format.supports(data) {
+			continue
+		}
+		q := acceptQuality(ranges, format.mediaType)
+		// aliases only count when asked for by name, otherwise text/*
+		// would also match XML through text/xml
+		for _, rng := range ranges {
+			if slices.Contains(format.aliases, rng.mediaType) {
+				q = max(q, rng.q)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	if best < 0 {
+		return renderFormat{}, false
+	}
+	return renderFormats[best], true
+}
+
+// a single media range from an Accept header
+type mediaRange struct {
+	mediaType string // type/subtype, either of which may be *
+	q         float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		if mediaType == "*" {
+			mediaType = "*/*"
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(key) == "q" {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// the q value of the most specific range matching the media type
+func acceptQuality(ranges []mediaRange, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, rng := range ranges {
+		var s int
+		switch rng.mediaType {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = rng.q, s
+		}
+	}
+	return q
+}
+
+// to render formats other than JSON we turn the envelope into plain
+// maps, slices and values the same way encoding/json sees it, keeping
+// the order of the fields of the structs (which a map would lose)
+type orderedField struct {
+	key   string
+	value any
+}
+
+type orderedObject []orderedField
+
+func toOrdered(data envelope) (orderedObject, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	value, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := value.(orderedObject)
+	if !ok {
+		return nil, errors.New("the envelope is not a JSON object")
+	}
+	return object, nil
+}
+
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := orderedObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, orderedField{key: key.(string), value: value})
+		}
+		_, err = dec.Token() // the closing }
+		return object, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token() // the closing ]
+		return list, err
+	default:
+		return token, nil
+	}
+}
+
+// the text form of a scalar value
+func scalarString(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		js, _ := json.Marshal(value)
+		return string(js)
+	}
+}
+
+// XML has no maps or arrays, so objects become nested elements and the
+// items of a list are wrapped in an element named after the list
+func renderXML(data envelope) ([]byte, error) {
+	object, err := toOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+	err = encodeXML(enc, "response", object)
+	if err == nil {
+		err = enc.Flush()
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), err
+}
+
+func encodeXML(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case orderedObject:
+		for _, field := range value {
+			if err := encodeXML(enc, field.key, field.value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		item := singular(xmlName(name))
+		for _, element := range value {
+			if err := encodeXML(enc, item, element); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalarString(value))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// turn a JSON key such as "@metadata" into a valid element name
+func xmlName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		default:
+			return -1
+		}
+	}, key)
+	if name == "" || strings.ContainsRune("0123456789-.", rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// quotes -> quote, anything we can't guess -> item
+func singular(name string) string {
+	if len(name) > 1 && strings.HasSuffix(name, "s") {
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+func renderYAML(data envelope) ([]byte, error) {
+	object, err := toOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(yamlNode(object))
+	if err == nil {
+		err = enc.Close()
+	}
+	return buf.Bytes(), err
+}
+
+func yamlNode(value any) *yaml.Node {
+	switch value := value.(type) {
+	case orderedObject:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range value {
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.key},
+				yamlNode(field.value))
+		}
+		return node
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, element := range value {
+			node.Content = append(node.Content, yamlNode(element))
+		}
+		return node
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(value.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value.String()}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(value)}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: scalarString(value)}
+	}
+}
+
+// the list in a list endpoint's envelope, e.g. "quotes". Metadata such
+// as "@metadata" is not a list of records, so it never qualifies.
+func listOf(data envelope) (string, bool) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if data[key] != nil && reflect.TypeOf(data[key]).Kind() == reflect.Slice {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// one row per record of the list, one column per field of the first record
+func renderCSV(data envelope) ([]byte, error) {
+	key, ok := listOf(data)
+	if !ok {
+		return nil, errors.New("the envelope does not contain a list")
+	}
+	object, err := toOrdered(envelope{key: data[key]})
+	if err != nil {
+		return nil, err
+	}
+	// a nil slice is encoded as null, which is just an empty list to us
+	records, _ := object[0].value.([]any)
+
+	var buf bytes.Buffer
+	if len(records) == 0 {
+		return buf.Bytes(), nil
+	}
+	w := csv.NewWriter(&buf)
+	var columns []string
+	if first, ok := records[0].(orderedObject); ok {
+		for _, field := range first {
+			columns = append(columns, field.key)
+		}
+	}
+	if columns == nil {
+		columns = []string{"value"}
+	}
+	w.Write(columns)
+	for _, record := range records {
+		row := make([]string, len(columns))
+		fields, ok := record.(orderedObject)
+		if !ok {
+			row[0] = scalarString(record)
+		}
+		for _, field := range fields {
+			for i, column := range columns {
+				if field.key == column {
+					row[i] = csvValue(field.value)
+				}
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// nested objects and lists don't fit in a cell, so they are kept as JSON
+func csvValue(value any) string {
+	switch value.(type) {
+	case orderedObject, []any:
+		var buf bytes.Buffer
+		writeOrderedJSON(&buf, value)
+		return buf.String()
+	default:
+		return scalarString(value)
+	}
+}
+
+func writeOrderedJSON(buf *bytes.Buffer, value any) {
+	switch value := value.(type) {
+	case orderedObject:
+		buf.WriteByte('{')
+		for i, field := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(field.key)
+			buf.Write(key)
+			buf.WriteByte(':')
+			writeOrderedJSON(buf, field.value)
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, element := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeOrderedJSON(buf, element)
+		}
+		buf.WriteByte(']')
+	default:
+		js, _ := json.Marshal(value)
+		buf.Write(js)
+	}
+}
+
+// reports if the envelope holds quotes, even if the list of them is empty
+func hasQuotes(data envelope) bool {
+	_, single := data["quote"]
+	_, list := data["quotes"]
+	return single || list
+}
+
+// the quotes held in an envelope, either a single "quote" or a "quotes" list
+func quotesOf(data envelope) []orderedObject {
+	var quotes []orderedObject
+	for _, key := range []string{"quote", "quotes"} {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		object, err := toOrdered(envelope{key: data[key]})
+		if err != nil {
+			continue
+		}
+		switch value := object[0].value.(type) {
+		case orderedObject:
+			quotes = append(quotes, value)
+		case []any:
+			for _, element := range value {
+				if quote, ok := element.(orderedObject); ok {
+					quotes = append(quotes, quote)
+				}
+			}
+		}
+	}
+	return quotes
+}
+
+// the classic fortune(6) layout: the quote, its author underneath and
+// a line with a single % between quotes
+func renderFortune(data envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, quote := range quotesOf(data) {
+		if i > 0 {
+			buf.WriteString("%\n")
+		}
+		var content, author string
+		for _, field := range quote {
+			switch field.key {
+			case "content":
+				content = scalarString(field.value)
+			case "author":
+				author = scalarString(field.value)
+			}
+		}
+		fmt.Fprintf(&buf, "%s\n\t-- %s\n", content, author)
+	}
+	return buf.Bytes(), nil
+}
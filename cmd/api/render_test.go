@@ -0,0 +1,185 @@
+// Filename: cmd/api/render_test.go
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// what the handlers put in their envelopes, in a shape the tests control
+type renderTestQuote struct {
+	ID      int64  `json:"id"`
+	Content string `json:"content"`
+	Author  string `json:"author"`
+}
+
+var (
+	renderTestList = envelope{
+		"quotes": []renderTestQuote{
+			{1, "Be kind.", "Anonymous"},
+			{2, `Say "yes", then learn`, "<Me> & you"},
+		},
+		"@metadata": map[string]int{"total_records": 2},
+	}
+	renderTestSingle = envelope{"quote": renderTestQuote{1, "Be kind.", "Anonymous"}}
+	renderTestOther  = envelope{"status": "available"}
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	tests := []struct {
+		name   string
+		accept string
+		data   envelope
+		want   string // "" for a 406
+	}{
+		{"missing", "", renderTestSingle, "application/json"},
+		{"empty list", " , ", renderTestSingle, "application/json"},
+		{"json", "application/json", renderTestSingle, "application/json"},
+		{"xml", "application/xml", renderTestSingle, "application/xml"},
+		{"xml alias", "text/xml", renderTestSingle, "application/xml"},
+		{"yaml alias", "application/x-yaml", renderTestSingle, "application/yaml"},
+		{"csv list", "text/csv", renderTestList, "text/csv"},
+		{"csv single", "text/csv", renderTestSingle, ""},
+		{"fortune", "text/plain", renderTestSingle, "text/plain"},
+		{"fortune without quotes", "text/plain", renderTestOther, ""},
+		{"highest q", "application/xml;q=0.5, application/yaml", renderTestSingle, "application/yaml"},
+		{"subtype wildcard", "text/*", renderTestList, "text/csv"},
+		{"subtype wildcard single", "text/*", renderTestSingle, "text/plain"},
+		{"application wildcard", "application/*", renderTestSingle, "application/json"},
+		{"most specific range wins", "application/*;q=0.1, application/yaml;q=0.5", renderTestSingle, "application/yaml"},
+		{"nothing we have", "image/png", renderTestSingle, ""},
+		{"html only", "text/html", renderTestSingle, ""},
+		{"anything", "*/*", renderTestSingle, "application/json"},
+		{"bare star", "*", renderTestSingle, "application/json"},
+		{"browser", browser, renderTestSingle, "application/json"},
+		{"browser on a list", browser, renderTestList, "application/json"},
+		{"format before anything", "text/csv, */*;q=0.1", renderTestList, "application/json"},
+		{"json ruled out", "application/json;q=0, */*", renderTestSingle, "application/xml"},
+		{"anything ruled out", "*/*;q=0, application/yaml", renderTestSingle, "application/yaml"},
+		{"case insensitive", "Application/XML", renderTestSingle, "application/xml"},
+		{"bad q is 1", "application/xml;q=2, application/yaml;q=0.5", renderTestSingle, "application/xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, ok := negotiateFormat(tt.accept, tt.data)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("got %s, want a 406", format.mediaType)
+				}
+				return
+			}
+			if !ok || format.mediaType != tt.want {
+				t.Errorf("got %q (ok = %v), want %s", format.mediaType, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		render func(envelope) ([]byte, error)
+		data   envelope
+		want   string
+	}{
+		{"xml", renderXML, renderTestList, `<?xml version="1.0" encoding="UTF-8"?>
+<response>
+	<metadata>
+		<total_records>2</total_records>
+	</metadata>
+	<quotes>
+		<quote>
+			<id>1</id>
+			<content>Be kind.</content>
+			<author>Anonymous</author>
+		</quote>
+		<quote>
+			<id>2</id>
+			<content>Say &#34;yes&#34;, then learn</content>
+			<author>&lt;Me&gt; &amp; you</author>
+		</quote>
+	</quotes>
+</response>
+`},
+		{"xml names", renderXML, envelope{"2nd try": "x", "": []int{1}}, `<?xml version="1.0" encoding="UTF-8"?>
+<response>
+	<_>
+		<item>1</item>
+	</_>
+	<_2ndtry>x</_2ndtry>
+</response>
+`},
+		{"yaml", renderYAML, renderTestList, `'@metadata':
+  total_records: 2
+quotes:
+  - id: 1
+    content: Be kind.
+    author: Anonymous
+  - id: 2
+    content: Say "yes", then learn
+    author: <Me> & you
+`},
+		{"csv", renderCSV, renderTestList, `id,content,author
+1,Be kind.,Anonymous
+2,"Say ""yes"", then learn",<Me> & you
+`},
+		{"csv empty list", renderCSV, envelope{"quotes": []renderTestQuote{}}, ""},
+		{"csv nested values", renderCSV, envelope{"items": []map[string]any{{"tags": []string{"a", "b"}}}},
+			"tags\n\"[\"\"a\"\",\"\"b\"\"]\"\n"},
+		{"fortune", renderFortune, renderTestList, "Be kind.\n\t-- Anonymous\n%\nSay \"yes\", then learn\n\t-- <Me> & you\n"},
+		{"fortune single", renderFortune, renderTestSingle, "Be kind.\n\t-- Anonymous\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := tt.render(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", body, tt.want)
+			}
+		})
+	}
+}
+
+// the headers and status the formats go out with
+func TestRender(t *testing.T) {
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"application/yaml", http.StatusOK, "application/yaml; charset=utf-8"},
+		{"text/plain", http.StatusOK, "text/plain; charset=utf-8"},
+		{"text/csv", http.StatusNotAcceptable, "application/problem+json"},
+	}
+	a := &applicationDependencies{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/quotes/1", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			err := a.render(w, r, http.StatusOK, renderTestSingle, http.Header{"X-Test": {"kept"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := w.Header().Values("Vary"); len(got) == 0 || got[0] != "Accept" {
+				t.Errorf("Vary = %v, want Accept first", got)
+			}
+			if tt.status == http.StatusOK && w.Header().Get("X-Test") != "kept" {
+				t.Error("the handler's headers were dropped")
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Filename: cmd/api/reportsHandler.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// let a reader tell us something is wrong with a quote
+func (a *applicationDependencies) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	quote, ok := a.readApprovedQuote(w, r)
+	if !ok {
+		return
+	}
+	var incomingData struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	report := &data.Report{
+		QuoteID: quote.ID,
+		UserID:  a.contextGetUser(r).ID,
+		Reason:  incomingData.Reason,
+		Note:    data.NormalizeText(incomingData.Note),
+	}
+	v := validator.New()
+	data.ValidateReport(v, report)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hidden, err := a.reportModel.Insert(report, a.config.reports.threshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateReport):
+			a.duplicateReportResponse(w, r, v)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if hidden {
+		a.logger.InfoContext(r.Context(), "quote hidden for review", "quote_id", quote.ID, "threshold", a.config.reports.threshold)
+	}
+	data := envelope{
+		"report": report,
+	}
+	err = a.writeJSON(w, r, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// the reports for the moderators, ?status=open|resolved|all
+// (open by default) and ?quote_id= to see those of a single quote
+func (a *applicationDependencies) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+	var reportFilters data.ReportFilters
+	var filters data.Filters
+
+	v := validator.New()
+	reportFilters.Status = a.getSingleQueryParameter(queryParameters, "status", "open")
+	v.OneOf("status", reportFilters.Status, "open", "resolved", "all")
+	reportFilters.QuoteID = int64(a.getSingleIntegerParameter(queryParameters, "quote_id", 0, v))
+	filters.Page = a.getSingleIntegerParameter(queryParameters, "page", 1, v)
+	filters.PageSize = a.getSingleIntegerParameter(queryParameters, "page_size", 20, v)
+	filters.Sort = a.getSingleQueryParameter(queryParameters, "sort", "id")
+	filters.SortSafelist = []string{"id", "-id"}
+
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reports, metadata, err := a.reportModel.GetAll(reportFilters, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"reports":   reports,
+		"@metadata": metadata,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// close a report without approving or rejecting the quote,
+// e.g. because it was fixed or there was nothing wrong with it
+func (a *applicationDependencies) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	report, err := a.reportModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var incomingData struct {
+		Resolution string `json:"resolution"`
+	}
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.OneOf("resolution", incomingData.Resolution, data.ReportDismissed, data.ReportFixed)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.reportModel.Resolve(report, a.contextGetUser(r).ID, incomingData.Resolution)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"report": report,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
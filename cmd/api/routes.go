@@ -4,6 +4,8 @@ package main
 import (
 	"net/http"
 
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/tracing"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -15,15 +17,110 @@ func (a *applicationDependencies) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	// handle 405
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
+	// the same routes without the handlers, so that the requests turned
+	// away before reaching the router (rate limited, bad token...) are
+	// still known by their route in the logs and metrics
+	patterns := httprouter.New()
+	// every route records its pattern for the logs and metrics,
+	// and times its handler in a span
+	handle := func(method string, pattern string, handler http.HandlerFunc) {
+		patterns.Handle(method, pattern, func(_ http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			a.contextSetRoutePattern(r, pattern)
+		})
+		router.HandlerFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+			a.contextSetRoutePattern(r, pattern)
+			ctx, span := tracing.Start(r.Context(), "handler "+pattern, tracing.KindInternal)
+			defer span.End()
+			handler(w, r.WithContext(ctx))
+		})
+	}
 	// setup routes
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/quotes", a.createQuoteHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/quotes/:id", a.displayQuoteHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/quotes/:id", a.updateQuoteHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/quotes/:id", a.deleteQuoteHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/quotes", a.listQuotesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users", a.registerUserHandler)
-
-	// Chain: CORS -> RateLimit -> RecoverPanic
-	return a.recoverPanic(a.rateLimit(a.enableCORS(router)))
+	handle(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", a.liveHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", a.readyHandler)
+	handle(http.MethodPost, "/v1/quotes", a.createQuoteHandler)
+	handle(http.MethodPost, "/v1/quotes/:id", a.quoteActionHandler)
+	handle(http.MethodGet, "/v1/quotes/:id", a.quoteSubresourceHandler)
+	handle(http.MethodPatch, "/v1/quotes/:id", a.requirePermission(data.PermissionModerateQuotes, a.updateQuoteHandler))
+	handle(http.MethodDelete, "/v1/quotes/:id", a.requirePermission(data.PermissionModerateQuotes, a.deleteQuoteHandler))
+	handle(http.MethodGet, "/v1/quotes/:id/translations", a.listTranslationsHandler)
+	handle(http.MethodGet, "/v1/quotes/:id/vote", a.requireAuthenticatedUser(a.displayVoteHandler))
+	handle(http.MethodPut, "/v1/quotes/:id/vote", a.requireAuthenticatedUser(a.voteQuoteHandler))
+	handle(http.MethodDelete, "/v1/quotes/:id/vote", a.requireAuthenticatedUser(a.deleteVoteHandler))
+	handle(http.MethodPost, "/v1/quotes/:id/reports", a.requireAuthenticatedUser(a.createReportHandler))
+	handle(http.MethodGet, "/v1/quotes", a.listQuotesHandler)
+	handle(http.MethodPost, "/v1/users", a.registerUserHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
+
+	handle(http.MethodGet, "/v1/users/me/favorites", a.requireAuthenticatedUser(a.listFavoritesHandler))
+	handle(http.MethodPut, "/v1/users/me/favorites/:quote_id", a.requireAuthenticatedUser(a.addFavoriteHandler))
+	handle(http.MethodDelete, "/v1/users/me/favorites/:quote_id", a.requireAuthenticatedUser(a.removeFavoriteHandler))
+
+	handle(http.MethodPost, "/v1/collections", a.requireAuthenticatedUser(a.createCollectionHandler))
+	handle(http.MethodGet, "/v1/collections", a.requireAuthenticatedUser(a.listCollectionsHandler))
+	// public collections can be shared, so anyone may look at them
+	handle(http.MethodGet, "/v1/collections/:id", a.displayCollectionHandler)
+	handle(http.MethodPatch, "/v1/collections/:id", a.requireAuthenticatedUser(a.updateCollectionHandler))
+	handle(http.MethodDelete, "/v1/collections/:id", a.requireAuthenticatedUser(a.deleteCollectionHandler))
+	handle(http.MethodPut, "/v1/collections/:id/quotes", a.requireAuthenticatedUser(a.reorderCollectionHandler))
+	handle(http.MethodPut, "/v1/collections/:id/quotes/:quote_id", a.requireAuthenticatedUser(a.addCollectionQuoteHandler))
+	handle(http.MethodDelete, "/v1/collections/:id/quotes/:quote_id", a.requireAuthenticatedUser(a.removeCollectionQuoteHandler))
+
+	handle(http.MethodGet, "/v1/moderation/queue", a.requirePermission(data.PermissionModerateQuotes, a.moderationQueueHandler))
+	handle(http.MethodPost, "/v1/moderation/queue/:id/approve", a.requirePermission(data.PermissionModerateQuotes, a.approveQuoteHandler))
+	handle(http.MethodPost, "/v1/moderation/queue/:id/reject", a.requirePermission(data.PermissionModerateQuotes, a.rejectQuoteHandler))
+	handle(http.MethodGet, "/v1/moderation/log", a.requirePermission(data.PermissionModerateQuotes, a.moderationLogHandler))
+	handle(http.MethodGet, "/v1/moderation/reports", a.requirePermission(data.PermissionModerateQuotes, a.listReportsHandler))
+	handle(http.MethodPost, "/v1/moderation/reports/:id/resolve", a.requirePermission(data.PermissionModerateQuotes, a.resolveReportHandler))
+
+	// name the route before anything can turn the request away
+	resolveRoute := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, _, _ := patterns.Lookup(r.Method, r.URL.Path); h != nil {
+				h(w, r, nil)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	// Chain: Authenticate -> CORS -> RateLimit -> ResolveRoute -> Compression -> Metrics -> LogRequest -> Trace -> RequestID -> RecoverPanic
+	// recoverPanic comes last, so that a panic in any other middleware still gets a 500
+	return a.recoverPanic(a.requestID(a.trace(a.logRequest(a.metricsMiddleware(a.compressResponse(resolveRoute(a.rateLimit(a.enableCORS(a.authenticate(a.readYourWrites(router)))))))))))
+}
+
+// httprouter does not allow a static path segment to sit next to the
+// :id wildcard, so GET /v1/quotes/random, /v1/quotes/export and friends have to be routed
+// through /v1/quotes/:id and dispatched from here
+func (a *applicationDependencies) quoteSubresourceHandler(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	switch id {
+	case "random", "export", "daily", "trending":
+		// these are routes of their own as far as the metrics go
+		a.contextSetRoutePattern(r, "/v1/quotes/"+id)
+	}
+	switch id {
+	case "random":
+		a.randomQuotesHandler(w, r)
+	case "export":
+		a.exportQuotesHandler(w, r)
+	case "daily":
+		a.dailyQuoteHandler(w, r)
+	case "trending":
+		a.trendingQuotesHandler(w, r)
+	default:
+		a.displayQuoteHandler(w, r)
+	}
+}
+
+// the same goes for POST /v1/quotes/import, which can't sit next to
+// POST /v1/quotes/:id/reports. No other quote takes a POST
+func (a *applicationDependencies) quoteActionHandler(w http.ResponseWriter, r *http.Request) {
+	switch httprouter.ParamsFromContext(r.Context()).ByName("id") {
+	case "import":
+		a.contextSetRoutePattern(r, "/v1/quotes/import")
+		a.requirePermission(data.PermissionModerateQuotes, a.importQuotesHandler)(w, r)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE, OPTIONS")
+		a.methodNotAllowedResponse(w, r)
+	}
 }
@@ -25,17 +25,82 @@ func (a *applicationDependencies) serve() error {
 	// create a channel to keep track of any errors during the shutdown process
 
 	shutdownError := make(chan error)
+
+	// the background workers run until the server shuts down
+	workers, stopWorkers := context.WithCancel(context.Background())
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.views.run(workers, a.config.views.flushInterval)
+	}()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.watchLogLevel(workers)
+	}()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.replicas.Run(workers, 2*time.Second)
+	}()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.writers.run(workers, time.Minute)
+	}()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.listenForQuoteChanges(workers)
+	}()
+	if a.spanExporter != nil {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.spanExporter.Run(workers, 5*time.Second)
+		}()
+	}
+
+	// the metrics get a server of their own, away from the API
+	var metricsServer *http.Server
+	if a.config.metrics.addr != "" {
+		metricsServer = a.metricsServer()
+		go func() {
+			a.logger.Info("starting metrics server", "addr", metricsServer.Addr)
+			err := metricsServer.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error("metrics server error", "err", err)
+			}
+		}()
+	}
+
 	// run the server in a goroutine so that it doesn't block the graceful shutdown handling below
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-quit
 		a.logger.Info("shutting down server", "signal", sig.String())
+		// stop saying we are ready, then give the load balancers
+		// time to notice before we stop taking requests
+		a.shuttingDown.Store(true)
+		if a.config.shutdown.drain > 0 {
+			a.logger.Info("draining traffic", "for", a.config.shutdown.drain.String())
+			time.Sleep(a.config.shutdown.drain)
+		}
 		// create a context to attempt a graceful 5 second shutdown
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		// call the server's Shutdown() method which is what will trigger all of our
-		shutdownError <- apiServer.Shutdown(ctx)
+		err := apiServer.Shutdown(ctx)
+		if metricsServer != nil {
+			metricsServer.Shutdown(ctx)
+		}
+		// let the workers finish up (e.g. save the last views) once
+		// no more requests are coming in
+		a.logger.Info("completing background tasks", "addr", apiServer.Addr)
+		stopWorkers()
+		a.wg.Wait()
+		shutdownError <- err
 	}()
 
 	a.logger.Info("starting server", "addr", apiServer.Addr, "env", a.config.environment)
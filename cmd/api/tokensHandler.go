@@ -0,0 +1,80 @@
+// Filename: cmd/api/tokensHandler.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/tracing"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// how long an authentication token is good for
+const authenticationTokenTTL = 24 * time.Hour
+
+// log a user in: trade their email address and password for a token
+// to send in the Authorization header of the next requests
+func (a *applicationDependencies) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// don't tell the client which of the two was wrong
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// bcrypt is slow on purpose, so it gets a span of its own
+	_, span := tracing.Start(r.Context(), "bcrypt.compare", tracing.KindInternal)
+	match, err := user.Password.Matches(incomingData.Password)
+	span.End()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+	// only once the password matched, so that this doesn't tell anyone
+	// which email addresses have an account
+	if !user.Activated {
+		a.inactiveAccountResponse(w, r)
+		return
+	}
+
+	token, err := a.tokenModel.New(user.ID, authenticationTokenTTL, data.ScopeAuthentication)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data := envelope{
+		"authentication_token": token,
+	}
+	err = a.writeJSON(w, r, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
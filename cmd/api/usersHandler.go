@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/tracing"
 	"github.com/amilcar-vasquez/qod/internal/validator"
 )
 
@@ -29,7 +30,9 @@ func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter,
 		Activated: false,
 	}
 	// hash the password and store it along with the cleartext version
+	_, span := tracing.Start(r.Context(), "bcrypt.hash", tracing.KindInternal)
 	err = user.Password.Set(incomingData.Password)
+	span.End()
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -42,11 +45,14 @@ func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter,
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = a.userModel.Insert(user) // we will add userModel to main() later
+	err = a.userModel.Insert(r.Context(), user) // we will add userModel to main() later
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
+			v.AddFieldError("email", validator.FieldError{
+				Code:    validator.CodeAlreadyExists,
+				Message: "a user with this email address already exists",
+			})
 			a.failedValidationResponse(w, r, v.Errors)
 		default:
 			a.serverErrorResponse(w, r, err)
@@ -57,7 +63,7 @@ func (a *applicationDependencies) registerUserHandler(w http.ResponseWriter,
 		"user": user,
 	}
 	// Status code 201 resource created
-	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	err = a.writeJSON(w, r, http.StatusCreated, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,103 @@
+// Filename: cmd/api/views.go
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+)
+
+// viewCounter counts the views of quotes in memory, so that reading a
+// quote doesn't cost a write. The counts are added to the database every
+// so often by run()
+type viewCounter struct {
+	mu      sync.Mutex
+	counts  map[int64]int64
+	model   *data.ViewModel
+	logger  *slog.Logger
+	running bool
+	lastErr error // of the last flush, for the readiness check
+}
+
+func newViewCounter(model *data.ViewModel, logger *slog.Logger) *viewCounter {
+	return &viewCounter{
+		counts: make(map[int64]int64),
+		model:  model,
+		logger: logger,
+	}
+}
+
+// count one view of each of the quotes
+func (c *viewCounter) add(quotes ...*data.Quote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, quote := range quotes {
+		c.counts[quote.ID]++
+	}
+}
+
+// write the counts to the database. If that fails they are kept
+// and go out with the next flush
+func (c *viewCounter) flush() {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = make(map[int64]int64)
+	c.mu.Unlock()
+
+	err := c.model.Add(time.Now(), counts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+	if err != nil {
+		c.logger.Error("unable to save quote views", "error", err.Error(), "quotes", len(counts))
+		for id, count := range counts {
+			c.counts[id] += count
+		}
+	}
+}
+
+// report if the counts are being saved
+func (c *viewCounter) health() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return errors.New("not running")
+	}
+	return c.lastErr
+}
+
+func (c *viewCounter) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+// flush the counts every interval, and drop the ones past their
+// retention once an hour, until ctx is done. The last counts are
+// flushed on the way out
+func (c *viewCounter) run(ctx context.Context, interval time.Duration) {
+	c.setRunning(true)
+	defer c.setRunning(false)
+	flushes := time.NewTicker(interval)
+	defer flushes.Stop()
+	prunes := time.NewTicker(time.Hour)
+	defer prunes.Stop()
+	for {
+		select {
+		case <-flushes.C:
+			c.flush()
+		case <-prunes.C:
+			err := c.model.DeleteBefore(time.Now().Add(-data.ViewRetention))
+			if err != nil {
+				c.logger.Error("unable to delete old quote views", "error", err.Error())
+			}
+		case <-ctx.Done():
+			c.flush()
+			return
+		}
+	}
+}
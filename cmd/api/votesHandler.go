@@ -0,0 +1,116 @@
+// Filename: cmd/api/votesHandler.go
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/amilcar-vasquez/qod/internal/data"
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// rate a quote from 1 to 5 stars. Voting again replaces the previous vote
+func (a *applicationDependencies) voteQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	quote, ok := a.readApprovedQuote(w, r)
+	if !ok {
+		return
+	}
+	var incomingData struct {
+		Rating int `json:"rating"`
+	}
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateRating(v, incomingData.Rating)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	err = a.voteModel.Upsert(user.ID, quote, incomingData.Rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"quote":  quote,
+		"rating": incomingData.Rating,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// the rating the user gave a quote
+func (a *applicationDependencies) displayVoteHandler(w http.ResponseWriter, r *http.Request) {
+	quote, ok := a.readApprovedQuote(w, r)
+	if !ok {
+		return
+	}
+	user := a.contextGetUser(r)
+	rating, err := a.voteModel.Get(user.ID, quote.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"quote":  quote,
+		"rating": rating,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// take back the user's vote on a quote
+func (a *applicationDependencies) deleteVoteHandler(w http.ResponseWriter, r *http.Request) {
+	quote, ok := a.readApprovedQuote(w, r)
+	if !ok {
+		return
+	}
+	user := a.contextGetUser(r)
+	err := a.voteModel.Delete(user.ID, quote)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data := envelope{
+		"quote": quote,
+	}
+	err = a.writeJSON(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// get the quote of the :id parameter, or send back a 404.
+// Only approved quotes can be voted on or reported
+func (a *applicationDependencies) readApprovedQuote(w http.ResponseWriter, r *http.Request) (*data.Quote, bool) {
+	quote, ok := a.readVisibleQuote(w, r)
+	if ok && quote.Status != data.QuoteStatusApproved {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	return quote, ok
+}
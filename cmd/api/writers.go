@@ -0,0 +1,64 @@
+// Filename: cmd/api/writers.go
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// recentWriters remembers the clients that wrote something lately, so
+// that readYourWrites can send their reads to the primary until the
+// replicas have caught up
+type recentWriters struct {
+	window time.Duration
+
+	mu sync.Mutex
+	// when each client may go back to reading from the replicas
+	until map[string]time.Time
+}
+
+func newRecentWriters(window time.Duration) *recentWriters {
+	return &recentWriters{window: window, until: make(map[string]time.Time)}
+}
+
+// the client just wrote something
+func (w *recentWriters) add(client string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.until[client] = time.Now().Add(w.window)
+}
+
+// reports if the client wrote something within the window
+func (w *recentWriters) recent(client string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	until, found := w.until[client]
+	return found && time.Now().Before(until)
+}
+
+// forget the clients whose window is over
+func (w *recentWriters) prune() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for client, until := range w.until {
+		if now.After(until) {
+			delete(w.until, client)
+		}
+	}
+}
+
+// prune every interval until ctx is done
+func (w *recentWriters) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.prune()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
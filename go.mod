@@ -3,8 +3,11 @@ module github.com/amilcar-vasquez/qod
 go 1.24.3
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
+	golang.org/x/text v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
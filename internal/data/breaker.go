@@ -0,0 +1,144 @@
+// Filename: internal/data/breaker.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CircuitBreaker stops us from opening connections to a database that
+// is down. After threshold connections in a row fail it opens, and for
+// the cooldown every query fails right away with ErrDatabaseUnavailable
+// instead of waiting on a dial that is going to time out. Then a single
+// connection is let through to see if the database is back
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// a connection is trying the database after the cooldown
+	probing bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Open reports if queries are failing fast
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+// RetryAfter is how long until the database will be tried again,
+// zero when the breaker is closed
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return 0
+	}
+	return max(time.Until(b.openUntil), 0)
+}
+
+// allow reports if a connection may be attempted
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *CircuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *CircuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// probeDone lets another connection try the database
+func (b *CircuitBreaker) probeDone() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// breakerConnector opens the connections of the pool through the breaker.
+// database/sql only dials when it has no usable connection, so a failed
+// dial is the database being unreachable rather than a bad query. Unless
+// the database did answer, e.g. to turn down our password: that is for
+// whoever configured us to fix, and trying again later won't help
+type breakerConnector struct {
+	driver.Connector
+	breaker *CircuitBreaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if !c.breaker.allow() {
+		return nil, ErrDatabaseUnavailable
+	}
+	conn, err := c.Connector.Connect(ctx)
+	switch {
+	case err == nil:
+		c.breaker.success()
+		return conn, nil
+	// the caller giving up isn't the database's fault
+	case errors.Is(ctx.Err(), context.Canceled):
+		c.breaker.probeDone()
+		return nil, err
+	case unreachable(ctx, err):
+		c.breaker.failure()
+		return nil, fmt.Errorf("%w: %w", ErrDatabaseUnavailable, err)
+	default:
+		// the database answered, so it is up
+		c.breaker.success()
+		return nil, err
+	}
+}
+
+// a dial failed because the database couldn't be reached, or isn't
+// taking connections right now. Running out of time counts: an
+// unreachable database often doesn't refuse the dial, it never answers it
+func unreachable(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || isConnectionError(err)
+}
+
+// OpenDB opens a PostgreSQL connection pool whose connections go
+// through the breaker
+func OpenDB(dsn string, breaker *CircuitBreaker) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(breakerConnector{Connector: connector, breaker: breaker}), nil
+}
@@ -0,0 +1,167 @@
+// Filename: internal/data/breaker_test.go
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// a connector that fails the way it is told to, counting its dials
+type stubConnector struct {
+	dials int
+	dial  func(ctx context.Context) error
+}
+
+func (c *stubConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.dials++
+	err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (c *stubConnector) Driver() driver.Driver { return nil }
+
+var errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+func TestCircuitBreaker(t *testing.T) {
+	// a dial that never gets an answer, until the caller's deadline
+	blackholed := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name string
+		dial func(ctx context.Context) error
+		// the context of every query
+		ctx      func() (context.Context, context.CancelFunc)
+		wantOpen bool
+	}{
+		{
+			name:     "refused",
+			dial:     func(context.Context) error { return errRefused },
+			ctx:      func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			wantOpen: true,
+		},
+		{
+			name: "timed out",
+			dial: blackholed,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Millisecond)
+			},
+			wantOpen: true,
+		},
+		{
+			name:     "starting up",
+			dial:     func(context.Context) error { return &pq.Error{Code: "57P03"} },
+			ctx:      func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			wantOpen: true,
+		},
+		{
+			name:     "wrong password",
+			dial:     func(context.Context) error { return &pq.Error{Code: "28P01"} },
+			ctx:      func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			wantOpen: false,
+		},
+		{
+			name:     "unknown database",
+			dial:     func(context.Context) error { return &pq.Error{Code: "3D000"} },
+			ctx:      func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			wantOpen: false,
+		},
+		{
+			name: "canceled by the caller",
+			dial: blackholed,
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantOpen: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewCircuitBreaker(3, time.Hour)
+			stub := &stubConnector{dial: tt.dial}
+			connector := breakerConnector{Connector: stub, breaker: breaker}
+			for range 3 {
+				ctx, cancel := tt.ctx()
+				_, err := connector.Connect(ctx)
+				cancel()
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				// only the database being down is reported as such
+				if errors.Is(err, ErrDatabaseUnavailable) != tt.wantOpen {
+					t.Errorf("error = %v, want ErrDatabaseUnavailable = %v", err, tt.wantOpen)
+				}
+			}
+			if breaker.Open() != tt.wantOpen {
+				t.Fatalf("open = %v, want %v", breaker.Open(), tt.wantOpen)
+			}
+			if !tt.wantOpen {
+				return
+			}
+
+			// once open, nothing is dialed
+			_, err := connector.Connect(context.Background())
+			if !errors.Is(err, ErrDatabaseUnavailable) {
+				t.Errorf("error = %v, want ErrDatabaseUnavailable", err)
+			}
+			if stub.dials != 3 {
+				t.Errorf("dialed %d times, want 3", stub.dials)
+			}
+			if breaker.RetryAfter() <= 0 {
+				t.Errorf("RetryAfter = %s, want the rest of the cooldown", breaker.RetryAfter())
+			}
+		})
+	}
+}
+
+// after the cooldown one connection at a time tries the database, the
+// first that gets through closes the breaker
+func TestCircuitBreakerProbe(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Millisecond)
+	stub := &stubConnector{dial: func(context.Context) error { return errRefused }}
+	connector := breakerConnector{Connector: stub, breaker: breaker}
+
+	_, err := connector.Connect(context.Background())
+	if !errors.Is(err, ErrDatabaseUnavailable) || !errors.Is(err, errRefused) {
+		t.Fatalf("error = %v, want both ErrDatabaseUnavailable and the dial's", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// the probe fails, the breaker opens again
+	_, _ = connector.Connect(context.Background())
+	if stub.dials != 2 || !breaker.Open() {
+		t.Fatalf("dials = %d, open = %v; want 2, true", stub.dials, breaker.Open())
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// only one probe is let through at a time
+	if !breaker.allow() {
+		t.Fatal("want the probe to be allowed")
+	}
+	if breaker.allow() {
+		t.Error("want a second probe to wait for the first")
+	}
+	breaker.success()
+	if breaker.Open() {
+		t.Error("want the breaker closed after a successful probe")
+	}
+
+	stub.dial = func(context.Context) error { return nil }
+	_, err = connector.Connect(context.Background())
+	if err != nil {
+		t.Errorf("error = %v, want the connection", err)
+	}
+}
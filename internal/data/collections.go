@@ -0,0 +1,376 @@
+// Filename: internal/data/collections.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/validator"
+	"github.com/lib/pq"
+)
+
+// A Collection is a named, ordered list of quotes put together by a user.
+// Public collections can be seen by anyone who has the link
+type Collection struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	QuoteCount  int       `json:"quote_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int32     `json:"version"`
+	Quotes      []*Quote  `json:"quotes,omitempty"` // only filled in by GetQuotes
+}
+
+// the columns every query returning collections selects
+const collectionColumns = `id, user_id, name, description, public,
+	(SELECT COUNT(*) FROM collection_quotes WHERE collection_id = collections.id),
+	created_at, version`
+
+// where to scan a row of collectionColumns into
+func (collection *Collection) destinations() []any {
+	return []any{
+		&collection.ID,
+		&collection.UserID,
+		&collection.Name,
+		&collection.Description,
+		&collection.Public,
+		&collection.QuoteCount,
+		&collection.CreatedAt,
+		&collection.Version,
+	}
+}
+
+// Check the fields a user can set on a collection
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Required("name", collection.Name)
+	v.MaxLength("name", collection.Name, 100)
+	v.MaxLength("description", collection.Description, 500)
+}
+
+// A CollectionModel expects a connection pool
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert a new collection
+func (c CollectionModel) Insert(collection *Collection) error {
+	query := `
+	INSERT INTO collections (user_id, name, description, public)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version`
+	args := []any{collection.UserID, collection.Name, collection.Description, collection.Public}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Version)
+	if isUniqueViolation(err, "collections_user_name_key") {
+		return ErrDuplicateCollection
+	}
+	return err
+}
+
+// Get a specific collection based on its ID
+func (c CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM collections
+	WHERE id = $1`, collectionColumns)
+
+	var collection Collection
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := c.DB.QueryRowContext(ctx, query, id).Scan(collection.destinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &collection, nil
+}
+
+// Get a page of the collections of a user
+func (c CollectionModel) GetAllForUser(userID int64, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), %s
+	FROM collections
+	WHERE user_id = $1
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, collectionColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(append([]any{&totalRecords}, collection.destinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return collections, metadata, nil
+}
+
+// Get the quotes of a collection, in order
+func (c CollectionModel) GetQuotes(collectionID int64) ([]*Quote, error) {
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM qod
+	INNER JOIN collection_quotes ON collection_quotes.quote_id = qod.id
+	WHERE collection_quotes.collection_id = $1
+	ORDER BY collection_quotes.position`, quoteColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := c.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	quotes := []*Quote{}
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(quote.destinations()...)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, &quote)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// update the name, description and visibility of a collection
+func (c CollectionModel) Update(collection *Collection) error {
+	query := `
+	UPDATE collections
+	SET name = $1, description = $2, public = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+	args := []any{
+		collection.Name,
+		collection.Description,
+		collection.Public,
+		collection.ID,
+		collection.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "collections_user_name_key"):
+			return ErrDuplicateCollection
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// delete a specific collection based on its ID
+func (c CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM collections
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The quotes of a collection are kept at positions 1, 2, 3... Changing
+// them takes a few statements, so they run in a transaction holding a
+// lock on the collection, which keeps two changes from interleaving
+func (c CollectionModel) withLockedCollection(collectionID int64,
+	fn func(ctx context.Context, tx *sql.Tx) error) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+	SELECT id
+	FROM collections
+	WHERE id = $1
+	FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, collectionID).Scan(&collectionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// take a quote out of a collection and close the gap it leaves.
+// It reports false if the quote wasn't in the collection
+func removeCollectionQuote(ctx context.Context, tx *sql.Tx, collectionID int64, quoteID int64) (bool, error) {
+	query := `
+	DELETE FROM collection_quotes
+	WHERE collection_id = $1 AND quote_id = $2
+	RETURNING position`
+	var position int
+	err := tx.QueryRowContext(ctx, query, collectionID, quoteID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	query = `
+	UPDATE collection_quotes
+	SET position = position - 1
+	WHERE collection_id = $1 AND position > $2`
+	_, err = tx.ExecContext(ctx, query, collectionID, position)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Put a quote at a position (starting at 1) of a collection, moving
+// the quotes after it down. A position of 0, or past the end, appends
+// the quote. A quote already in the collection is moved.
+// The position the quote ended up at is stored back in position
+func (c CollectionModel) AddQuote(collectionID int64, quoteID int64, position *int) error {
+	return c.withLockedCollection(collectionID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := removeCollectionQuote(ctx, tx, collectionID, quoteID)
+		if err != nil {
+			return err
+		}
+
+		var last int
+		query := `
+		SELECT COALESCE(MAX(position), 0)
+		FROM collection_quotes
+		WHERE collection_id = $1`
+		err = tx.QueryRowContext(ctx, query, collectionID).Scan(&last)
+		if err != nil {
+			return err
+		}
+		if *position < 1 || *position > last {
+			*position = last + 1
+		}
+
+		query = `
+		UPDATE collection_quotes
+		SET position = position + 1
+		WHERE collection_id = $1 AND position >= $2`
+		_, err = tx.ExecContext(ctx, query, collectionID, *position)
+		if err != nil {
+			return err
+		}
+		query = `
+		INSERT INTO collection_quotes (collection_id, quote_id, position)
+		VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, collectionID, quoteID, *position)
+		if isForeignKeyViolation(err) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+}
+
+// Take a quote out of a collection
+func (c CollectionModel) RemoveQuote(collectionID int64, quoteID int64) error {
+	return c.withLockedCollection(collectionID, func(ctx context.Context, tx *sql.Tx) error {
+		removed, err := removeCollectionQuote(ctx, tx, collectionID, quoteID)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// Put the quotes of a collection in a new order. quoteIDs must hold
+// every quote of the collection exactly once, if the collection changed
+// since the caller looked at it we get an ErrEditConflict
+func (c CollectionModel) Reorder(collectionID int64, quoteIDs []int64) error {
+	return c.withLockedCollection(collectionID, func(ctx context.Context, tx *sql.Tx) error {
+		var current []int64
+		query := `
+		SELECT quote_id
+		FROM collection_quotes
+		WHERE collection_id = $1`
+		err := tx.QueryRowContext(ctx, `SELECT ARRAY(`+query+`)`, collectionID).Scan(pq.Array(&current))
+		if err != nil {
+			return err
+		}
+		sorted := slices.Sorted(slices.Values(quoteIDs))
+		slices.Sort(current)
+		if !slices.Equal(sorted, current) {
+			return ErrEditConflict
+		}
+
+		query = `
+		UPDATE collection_quotes
+		SET position = new.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS new(quote_id, position)
+		WHERE collection_quotes.collection_id = $1
+		AND collection_quotes.quote_id = new.quote_id`
+		_, err = tx.ExecContext(ctx, query, collectionID, pq.Array(quoteIDs))
+		return err
+	})
+}
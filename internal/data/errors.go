@@ -3,7 +3,30 @@ package data
 
 import (
 	"errors"
+
+	"github.com/lib/pq"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
 var ErrEditConflict = errors.New("edit conflict")
+var ErrDuplicateQuote = errors.New("duplicate quote")
+var ErrDuplicateTranslation = errors.New("duplicate translation")
+var ErrDuplicateCollection = errors.New("duplicate collection")
+var ErrDuplicateReport = errors.New("duplicate report")
+
+// the circuit breaker is open, or a connection to the database just failed
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// reports if err is PostgreSQL complaining about a duplicate
+// value in the given unique constraint (or index)
+func isUniqueViolation(err error, constraint string) bool {
+	var pqError *pq.Error
+	return errors.As(err, &pqError) && pqError.Code == "23505" && pqError.Constraint == constraint
+}
+
+// reports if err is PostgreSQL complaining that a row refers
+// to a row (e.g. a quote) that doesn't exist
+func isForeignKeyViolation(err error) bool {
+	var pqError *pq.Error
+	return errors.As(err, &pqError) && pqError.Code == "23503"
+}
@@ -0,0 +1,100 @@
+// Filename: internal/data/favorites.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A FavoriteModel expects a connection pool
+type FavoriteModel struct {
+	DB *sql.DB
+}
+
+// Add a quote to a user's favorites. It reports false if the
+// quote already was one of them
+func (f FavoriteModel) Add(userID int64, quoteID int64) (bool, error) {
+	query := `
+	INSERT INTO user_favorites (user_id, quote_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := f.DB.ExecContext(ctx, query, userID, quoteID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return false, ErrRecordNotFound
+		}
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Remove a quote from a user's favorites
+func (f FavoriteModel) Remove(userID int64, quoteID int64) error {
+	query := `
+	DELETE FROM user_favorites
+	WHERE user_id = $1 AND quote_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := f.DB.ExecContext(ctx, query, userID, quoteID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Get a page of a user's favorite quotes. Besides the quote columns,
+// they can be sorted by favorited_at (when they were added)
+func (f FavoriteModel) GetAll(userID int64, criteria QuoteCriteria, filters Filters) ([]*Quote, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), %s
+	FROM (
+		SELECT qod.*, user_favorites.created_at AS favorited_at
+		FROM qod
+		INNER JOIN user_favorites ON user_favorites.quote_id = qod.id
+		WHERE user_favorites.user_id = $5
+	) AS qod
+	WHERE %s
+	ORDER BY %s %s, id ASC
+	LIMIT $6 OFFSET $7`, quoteColumns, quoteCriteriaClause, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append(criteria.args(), userID, filters.limit(), filters.offset())
+	rows, err := f.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	quotes := []*Quote{}
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(append([]any{&totalRecords}, quote.destinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		quotes = append(quotes, &quote)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return quotes, metadata, nil
+}
@@ -26,13 +26,10 @@ type Metadata struct {
 // Next we validate page and PageSize
 // We follow the same approach that we used to validate a Comment
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 500, "page", "must be a maximum of 500")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Range("page", f.Page, 1, 500)
+	v.Range("page_size", f.PageSize, 1, 100)
 	// Check if sort fields provided are valid
-	// We will implement PermittedValue() later
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.OneOf("sort", f.Sort, f.SortSafelist...)
 
 }
 
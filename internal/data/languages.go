@@ -0,0 +1,64 @@
+// Filename: internal/data/languages.go
+package data
+
+import (
+	"golang.org/x/text/language"
+)
+
+// the language of quotes created without one
+const DefaultLanguage = "en"
+
+// the PostgreSQL text search configuration for the languages it has
+// one for. Anything else is searched with the language-agnostic 'simple'
+var textSearchConfigs = map[string]string{
+	"ar": "arabic",
+	"da": "danish",
+	"de": "german",
+	"el": "greek",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"id": "indonesian",
+	"it": "italian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// the text search configuration to use for a language tag
+func textSearchConfig(tag string) string {
+	if tag == "" {
+		return "simple"
+	}
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return "simple"
+	}
+	base, _ := parsed.Base()
+	if config, found := textSearchConfigs[base.String()]; found {
+		return config
+	}
+	return "simple"
+}
+
+// ValidLanguageTag reports if tag is a well-formed BCP 47 language tag
+func ValidLanguageTag(tag string) bool {
+	parsed, err := language.Parse(tag)
+	return err == nil && parsed != language.Und
+}
+
+// CanonicalLanguageTag puts a language tag in its canonical form, e.g.
+// "ES-mx" becomes "es-MX". Invalid tags are returned unchanged
+func CanonicalLanguageTag(tag string) string {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return tag
+	}
+	return parsed.String()
+}
@@ -0,0 +1,223 @@
+// Filename: internal/data/moderation.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// the statuses of a quote. Only approved quotes are shown to everyone,
+// the ones suggested by the public wait as pending for a moderator
+const (
+	QuoteStatusPending  = "pending"
+	QuoteStatusApproved = "approved"
+	QuoteStatusRejected = "rejected"
+)
+
+// the actions recorded in the moderation log
+const (
+	ModerationApprove = "approve"
+	ModerationReject  = "reject"
+	ModerationEdit    = "edit"
+	ModerationDelete  = "delete"
+	ModerationHide    = "hide" // by the reports, there is no moderator
+)
+
+// A ModerationEntry is one thing a moderator did to a quote
+type ModerationEntry struct {
+	ID          int64     `json:"id"`
+	QuoteID     int64     `json:"quote_id"`
+	ModeratorID *int64    `json:"moderator_id"` // nil for automatic actions
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Check the reason a moderator gives for a decision. Rejections
+// need one so that the submitter knows what to fix
+func ValidateModerationReason(v *validator.Validator, action string, reason string) {
+	if action == ModerationReject {
+		v.Required("reason", reason)
+	}
+	v.MaxLength("reason", reason, 500)
+}
+
+// A ModeratedQuote is a quote as the moderators see it, with who
+// submitted it and why it was rejected, which the public doesn't get
+type ModeratedQuote struct {
+	*Quote
+	SubmittedBy     *int64 `json:"submitted_by,omitempty"`
+	RejectionReason string `json:"rejection_reason,omitempty"`
+}
+
+// the moderators' view of a quote
+func (quote *Quote) Moderated() ModeratedQuote {
+	return ModeratedQuote{
+		Quote:           quote,
+		SubmittedBy:     quote.SubmittedBy,
+		RejectionReason: quote.RejectionReason,
+	}
+}
+
+// the moderators' view of a list of quotes
+func ModeratedQuotes(quotes []*Quote) []ModeratedQuote {
+	moderated := make([]ModeratedQuote, len(quotes))
+	for i, quote := range quotes {
+		moderated[i] = quote.Moderated()
+	}
+	return moderated
+}
+
+// A ModerationModel expects a connection pool
+type ModerationModel struct {
+	DB *sql.DB
+	// the cached quotes to drop after a change, can be nil
+	Cache *QuoteCache
+}
+
+// Get a page of the quotes waiting for a moderator
+func (m ModerationModel) GetQueue(filters Filters) ([]*Quote, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), %s
+	FROM qod
+	WHERE status = $1
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, quoteColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, QuoteStatusPending, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	quotes := []*Quote{}
+	for rows.Next() {
+		var quote Quote
+		err := rows.Scan(append([]any{&totalRecords}, quote.destinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		quotes = append(quotes, &quote)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return quotes, metadata, nil
+}
+
+// Approve or reject a quote and record the decision in the log, in a
+// single transaction. The quote gets its new status and rejection reason.
+// The decision also resolves the open reports on the quote
+func (m ModerationModel) Decide(quote *Quote, moderatorID int64, action string, reason string) error {
+	status := QuoteStatusApproved
+	rejectionReason := ""
+	if action == ModerationReject {
+		status = QuoteStatusRejected
+		rejectionReason = reason
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// the version makes sure the moderator decided on what they saw
+	query := `
+	UPDATE qod
+	SET status = $1, rejection_reason = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+	err = tx.QueryRowContext(ctx, query, status, rejectionReason, quote.ID, quote.Version).Scan(&quote.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = logModeration(ctx, tx, quote.ID, &moderatorID, action, reason)
+	if err != nil {
+		return err
+	}
+	err = resolveQuoteReports(ctx, tx, quote.ID, moderatorID, status)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(quote.ID)
+	quote.Status = status
+	quote.RejectionReason = rejectionReason
+	return nil
+}
+
+// what logModeration needs, so that it can run in a transaction or not
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// record something a moderator did, in the transaction that does it
+func logModeration(ctx context.Context, db execer, quoteID int64, moderatorID *int64, action string, reason string) error {
+	query := `
+	INSERT INTO moderation_log (quote_id, moderator_id, action, reason)
+	VALUES ($1, $2, $3, $4)`
+	_, err := db.ExecContext(ctx, query, quoteID, moderatorID, action, reason)
+	return err
+}
+
+// Get a page of the moderation log, the latest entries first.
+// A quoteID other than 0 only gets the entries of that quote
+func (m ModerationModel) GetLog(quoteID int64, filters Filters) ([]*ModerationEntry, Metadata, error) {
+	query := `
+	SELECT COUNT(*) OVER(), id, quote_id, moderator_id, action, reason, created_at
+	FROM moderation_log
+	WHERE quote_id = $1 OR $1 = 0
+	ORDER BY id DESC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, quoteID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	entries := []*ModerationEntry{}
+	for rows.Next() {
+		var entry ModerationEntry
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.QuoteID,
+			&entry.ModeratorID,
+			&entry.Action,
+			&entry.Reason,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
@@ -0,0 +1,52 @@
+// Filename: internal/data/normalize.go
+package data
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// invisible characters that only get in the way of comparing and
+// measuring text. Zero-width (non-)joiners are left alone since emoji
+// sequences and several scripts depend on them
+var invisibleRunes = map[rune]bool{
+	'\u00AD': true, // soft hyphen
+	'\u200B': true, // zero-width space
+	'\u2060': true, // word joiner
+	'\uFEFF': true, // zero-width no-break space (byte order mark)
+}
+
+// NormalizeText puts user supplied text in a canonical form: Unicode
+// NFC, control and zero-width characters removed and runs of whitespace
+// collapsed into a single space, with none at either end
+func NormalizeText(s string) string {
+	s = norm.NFC.String(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r), invisibleRunes[r]:
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// NormalizeQuote normalizes the text of a quote, it should be
+// called before the quote is validated
+func NormalizeQuote(quote *Quote) {
+	quote.Content = NormalizeText(quote.Content)
+	quote.Author = NormalizeText(quote.Author)
+	quote.Language = CanonicalLanguageTag(strings.TrimSpace(quote.Language))
+}
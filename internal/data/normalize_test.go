@@ -0,0 +1,51 @@
+// Filename: internal/data/normalize_test.go
+package data
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"only spaces", " \t\n ", ""},
+		{"trimmed", "  Be kind.  ", "Be kind."},
+		{"collapsed whitespace", "Be\t\tkind,\r\n  always.", "Be kind, always."},
+		{"unicode spaces", "a\u00A0b\u2028c\u3000d\u0085e", "a b c d e"},
+		{"composed", "Cafe\u0301", "Café"},
+		{"already composed", "Café", "Café"},
+		{"composed hangul", "\u1112\u1161\u11AB\u1100\u116E\u11A8", "한국"},
+		{"marks without a precomposed form", "q\u0307\u0323", "q\u0323\u0307"},
+		{"compatibility forms kept", "ｆｕｌｌ ﬁ", "ｆｕｌｌ ﬁ"},
+		{"cjk", "你好，世界", "你好，世界"},
+		{"zero-width space", "zero\u200Bwidth", "zerowidth"},
+		{"zero-width space between words", "a \u200B b", "a b"},
+		{"only invisible", "\u200B \uFEFF", ""},
+		{"soft hyphen", "hy\u00ADphen", "hyphen"},
+		{"word joiner", "word\u2060joiner", "wordjoiner"},
+		{"byte order mark", "\uFEFFBe kind.", "Be kind."},
+		{"control characters", "bell\u0007 nul\u0000 del\u007F c1\u0090", "bell nul del c1"},
+		{"emoji zwj sequence kept", "\U0001F468\u200D\U0001F469\u200D\U0001F467", "\U0001F468\u200D\U0001F469\u200D\U0001F467"},
+		{"zwnj kept", "می\u200Cخواهم", "می\u200Cخواهم"},
+		{"flags kept", "\U0001F1E7\U0001F1FF \U0001F1FA\U0001F1F8", "\U0001F1E7\U0001F1FF \U0001F1FA\U0001F1F8"},
+		{"skin tone kept", "\U0001F44D\U0001F3FD", "\U0001F44D\U0001F3FD"},
+		{"variation selector kept", "❤\uFE0F", "❤\uFE0F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeText(tt.value); got != tt.want {
+				t.Errorf("NormalizeText(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeQuote(t *testing.T) {
+	quote := &Quote{Content: "  Be\u200B kind.\n", Author: "Anon\u0000ymous ", Language: " ES-mx "}
+	NormalizeQuote(quote)
+	if quote.Content != "Be kind." || quote.Author != "Anonymous" || quote.Language != "es-MX" {
+		t.Errorf("got %q, %q, %q", quote.Content, quote.Author, quote.Language)
+	}
+}
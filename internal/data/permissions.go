@@ -0,0 +1,55 @@
+// Filename: internal/data/permissions.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+)
+
+// the permission codes
+const PermissionModerateQuotes = "quotes:moderate"
+
+// the permission codes a user has
+type Permissions []string
+
+// check if a permission code is in the slice
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// Setup the struct
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// Get all the permission codes of a user
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+        WHERE users_permissions.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
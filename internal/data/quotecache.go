@@ -0,0 +1,117 @@
+// Filename: internal/data/quotecache.go
+package data
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/cache"
+)
+
+// the channel the qod_notify_change trigger sends the id of every
+// quote that was inserted, updated or deleted on
+const QuoteChangesChannel = "qod_changes"
+
+// QuoteCache keeps the quotes read by QuoteModel.Get and GetDaily in
+// memory. It only knows that a quote changed when told so by Invalidate.
+// The models invalidate the quotes they change as soon as the change is
+// made, and the database's notifications cover the other instances, so
+// nothing is cached while they aren't being listened to: a change made
+// elsewhere could go unnoticed
+type QuoteCache struct {
+	quotes *cache.LRU[int64, Quote]
+	// the daily quote by day, a change to any quote may pick another one
+	daily *cache.LRU[string, Quote]
+
+	// changes to listening and generation are made under mu, a value
+	// read before an invalidation must not be added after it
+	mu        sync.Mutex
+	listening bool
+	// bumped by every invalidation
+	generation uint64
+	// when the last invalidation was made
+	changedAt time.Time
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewQuoteCache(size int) *QuoteCache {
+	return &QuoteCache{
+		quotes: cache.NewLRU[int64, Quote](size),
+		// today's, and yesterday's for the requests made around midnight
+		daily: cache.NewLRU[string, Quote](4),
+	}
+}
+
+// SetListening says if the changes are being listened to. The cache is
+// emptied either way: changes may have been missed while not listening
+func (c *QuoteCache) SetListening(listening bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listening = listening
+	c.generation++
+	c.changedAt = time.Now()
+	c.quotes.Purge()
+	c.daily.Purge()
+}
+
+// Invalidate drops the quote with the given id, and the daily quotes.
+// A nil cache has nothing to drop
+func (c *QuoteCache) Invalidate(id int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.changedAt = time.Now()
+	c.quotes.Remove(id)
+	c.daily.Purge()
+}
+
+// Len is the number of quotes in the cache
+func (c *QuoteCache) Len() int {
+	return c.quotes.Len()
+}
+
+// Hits is how many lookups found their quote
+func (c *QuoteCache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses is how many lookups had to go to the database
+func (c *QuoteCache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+// the generation to hand back to cacheAdd once the value has been read,
+// and if the value may be cached at all. The read may go to a replica
+// up to lag behind the primary, which may not have replayed a change
+// made less than lag ago: what it reads is served, but not cached
+func (c *QuoteCache) begin(lag time.Duration) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation, c.listening && time.Since(c.changedAt) >= lag
+}
+
+// add the value if nothing was invalidated since it was read
+func cacheAdd[K comparable](c *QuoteCache, lru *cache.LRU[K, Quote], key K, quote *Quote, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listening && c.generation == generation {
+		lru.Add(key, *quote.clone())
+	}
+}
+
+// look a quote up, counting the hit or miss
+func cacheLookup[K comparable](c *QuoteCache, lru *cache.LRU[K, Quote], key K) (*Quote, bool) {
+	quote, found := lru.Get(key)
+	if !found {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return quote.clone(), true
+}
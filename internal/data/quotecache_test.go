@@ -0,0 +1,127 @@
+// Filename: internal/data/quotecache_test.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a connection whose statements each change the given number of rows.
+// If log is set, the statements and how their transactions ended go in it
+type execConn struct {
+	rowsAffected int64
+	log          *[]string
+}
+
+func (c execConn) record(statement string) {
+	if c.log != nil {
+		*c.log = append(*c.log, statement)
+	}
+}
+
+func (c execConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.record(strings.Join(strings.Fields(query), " "))
+	return driver.RowsAffected(c.rowsAffected), nil
+}
+
+func (c execConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c execConn) Close() error                        { return nil }
+func (c execConn) Begin() (driver.Tx, error)           { return execTx{c}, nil }
+
+type execTx struct {
+	conn execConn
+}
+
+func (tx execTx) Commit() error   { tx.conn.record("COMMIT"); return nil }
+func (tx execTx) Rollback() error { tx.conn.record("ROLLBACK"); return nil }
+
+type execConnector struct {
+	conn execConn
+}
+
+func (c execConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c execConnector) Driver() driver.Driver                        { return nil }
+
+// the writer doesn't wait for the notification to stop seeing the quote
+func TestDeleteInvalidatesCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		rowsAffected int64
+		wantErr      error
+		wantCached   bool
+	}{
+		{"deleted", 1, nil, false},
+		{"not found", 0, ErrRecordNotFound, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := sql.OpenDB(execConnector{execConn{rowsAffected: tt.rowsAffected}})
+			defer db.Close()
+			quoteCache := NewQuoteCache(8)
+			quoteCache.SetListening(true)
+			generation, _ := quoteCache.begin(0)
+			cacheAdd(quoteCache, quoteCache.quotes, 7, &Quote{ID: 7}, generation)
+			cacheAdd(quoteCache, quoteCache.daily, "2026-10-18", &Quote{ID: 7}, generation)
+
+			model := QuoteModel{DB: db, Cache: quoteCache}
+			err := model.Delete(context.Background(), 7, 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			_, cached := quoteCache.quotes.Get(7)
+			_, dailyCached := quoteCache.daily.Get("2026-10-18")
+			if cached != tt.wantCached || dailyCached != tt.wantCached {
+				t.Errorf("quote cached = %v, daily cached = %v; want %v", cached, dailyCached, tt.wantCached)
+			}
+		})
+	}
+
+	// without a cache there is nothing to drop
+	db := sql.OpenDB(execConnector{execConn{rowsAffected: 1}})
+	defer db.Close()
+	err := QuoteModel{DB: db}.Delete(context.Background(), 7, 1)
+	if err != nil {
+		t.Errorf("error = %v, want nil", err)
+	}
+}
+
+// a replica may not have replayed a change yet, what it reads in the
+// meantime isn't cached
+func TestCacheWaitsForReplicas(t *testing.T) {
+	quoteCache := NewQuoteCache(8)
+	quoteCache.SetListening(true)
+	quoteCache.changedAt = time.Now().Add(-time.Minute)
+	if _, ok := quoteCache.begin(time.Second); !ok {
+		t.Error("want a fill long after the last change to be cached")
+	}
+
+	quoteCache.Invalidate(7)
+	if _, ok := quoteCache.begin(time.Second); ok {
+		t.Error("want a fill right after a change not to be cached")
+	}
+	// the primary has every change
+	if _, ok := quoteCache.begin(0); !ok {
+		t.Error("want a fill from the primary to be cached")
+	}
+
+	// and a fill that began before a change isn't added after it
+	generation, _ := quoteCache.begin(0)
+	quoteCache.Invalidate(7)
+	cacheAdd(quoteCache, quoteCache.quotes, 7, &Quote{ID: 7}, generation)
+	if _, found := quoteCache.quotes.Get(7); found {
+		t.Error("want the stale fill rejected")
+	}
+
+	var replicas *Replicas
+	if replicas.lag() != 0 || NewReplicas(nil, time.Second).lag() != 0 {
+		t.Error("want no lag without replicas")
+	}
+	if lag := NewReplicas([]*sql.DB{nil}, time.Second).lag(); lag != time.Second {
+		t.Errorf("lag = %s, want 1s", lag)
+	}
+}
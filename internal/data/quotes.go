@@ -6,63 +6,252 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
 	"time"
 
+	"github.com/amilcar-vasquez/qod/internal/tracing"
 	"github.com/amilcar-vasquez/qod/internal/validator"
+	"github.com/lib/pq"
 )
 
 // A QuoteModel expects a connection pool
 type QuoteModel struct {
 	DB *sql.DB
+	// the reads may go to these, nil to read from DB
+	Replicas *Replicas
+	// Get and GetDaily look here first, nil to not cache
+	Cache *QuoteCache
+}
+
+// run a read, with a timeout of its own, on the next healthy replica.
+// A replica that can't be reached is left out until its next check and
+// the read is run again on the primary, which is where it goes when there
+// is no replica to read from or the context asks for the primary
+func (q QuoteModel) read(ctx context.Context, fn func(ctx context.Context, db *sql.DB) error) error {
+	attempt := func(db *sql.DB) error {
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		return fn(ctx, db)
+	}
+	if replica := q.Replicas.pick(ctx); replica != nil {
+		err := attempt(replica.db)
+		if !isConnectionError(err) {
+			return err
+		}
+		replica.eject(err)
+	}
+	return attempt(q.DB)
+}
+
+// begin a read-only transaction the same way, for the reads that take
+// longer than a query's timeout
+func (q QuoteModel) beginRead(ctx context.Context) (*sql.Tx, error) {
+	options := &sql.TxOptions{ReadOnly: true}
+	if replica := q.Replicas.pick(ctx); replica != nil {
+		tx, err := replica.db.BeginTx(ctx, options)
+		if !isConnectionError(err) {
+			return tx, err
+		}
+		replica.eject(err)
+	}
+	return q.DB.BeginTx(ctx, options)
+}
+
+// QuoteCriteria holds the search filters that can be applied to
+// any query returning a set of quotes (list, random, ...)
+type QuoteCriteria struct {
+	Content  string // full-text match on the content
+	Author   string // full-text match on the author
+	Language string // BCP 47 tag, "es" also matches "es-MX"
+}
+
+// the WHERE clause matching a QuoteCriteria. The criteria always
+// take the first placeholders ($1 to $4), so any other arguments
+// of the query must start at $5. Only approved quotes ever match,
+// the others are only seen through the moderation queue
+const quoteCriteriaClause = `(to_tsvector($4::regconfig, content) @@ plainto_tsquery($4::regconfig, $1) OR $1 = '')
+	AND (to_tsvector('simple', author) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND (language = $3 OR language LIKE $3 || '-%' OR $3 = '')
+	AND status = 'approved'`
+
+// the arguments to pass along with quoteCriteriaClause
+func (c QuoteCriteria) args() []any {
+	return []any{c.Content, c.Author, c.Language, textSearchConfig(c.Language)}
 }
 
 // make our JSON keys be displayed in all lowercase
 // "-" means don't show this field
 type Quote struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Author    string    `json:"author"`
-	CreatedAt time.Time `json:"-"`
-	Version   int32     `json:"version"`
+	ID         int64   `json:"id"`
+	Content    string  `json:"content"`
+	Author     string  `json:"author"`
+	Language   string  `json:"language"`              // BCP 47 tag
+	OriginalID *int64  `json:"original_id,omitempty"` // set on translations
+	Score      float64 `json:"score"`                 // Bayesian average of the ratings
+	Votes      int     `json:"votes"`
+	// pending, approved or rejected, see moderation.go
+	Status string `json:"status"`
+	// only for the moderators to see, see ModeratedQuote
+	SubmittedBy     *int64    `json:"-"`
+	RejectionReason string    `json:"-"`
+	CreatedAt       time.Time `json:"-"`
+	Version         int32     `json:"version"`
+}
+
+// the columns every query returning quotes selects, in the order
+// that Quote.destinations() expects them
+const quoteColumns = `id, content, author, language, original_id, score, votes,
+	status, submitted_by, rejection_reason, created_at, version`
+
+// a copy that shares nothing with the quote, for the cache
+func (quote *Quote) clone() *Quote {
+	c := *quote
+	if quote.OriginalID != nil {
+		c.OriginalID = new(int64)
+		*c.OriginalID = *quote.OriginalID
+	}
+	if quote.SubmittedBy != nil {
+		c.SubmittedBy = new(int64)
+		*c.SubmittedBy = *quote.SubmittedBy
+	}
+	return &c
+}
+
+// where to scan a row of quoteColumns into
+func (quote *Quote) destinations() []any {
+	return []any{
+		&quote.ID,
+		&quote.Content,
+		&quote.Author,
+		&quote.Language,
+		&quote.OriginalID,
+		&quote.Score,
+		&quote.Votes,
+		&quote.Status,
+		&quote.SubmittedBy,
+		&quote.RejectionReason,
+		&quote.CreatedAt,
+		&quote.Version,
+	}
 }
 
 // Insert a new row in the quotes table
 // Expects a pointer to the actual quote
-func (q QuoteModel) Insert(quote *Quote) error {
+func (q QuoteModel) Insert(ctx context.Context, quote *Quote) error {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.Insert")
+	defer span.End()
 	query := `
-	INSERT INTO qod (content, author)
-	VALUES ($1, $2)
-	RETURNING id, created_at, version
+	INSERT INTO qod (content, author, language, original_id, status, submitted_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, score, votes, created_at, version
 	`
-	args := []any{quote.Content, quote.Author}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{quote.Content, quote.Author, quote.Language, quote.OriginalID, quote.Status, quote.SubmittedBy}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	return q.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := q.DB.QueryRowContext(ctx, query, args...).Scan(
 		&quote.ID,
+		&quote.Score,
+		&quote.Votes,
 		&quote.CreatedAt,
 		&quote.Version)
+	if isUniqueViolation(err, "qod_translation_language_idx") {
+		return ErrDuplicateTranslation
+	}
+	return err
 }
 
-// Get a specific quote based on its ID
-func (q QuoteModel) Get(id int64) (*Quote, error) {
+// Insert a batch of quotes, skipping the ones that already exist with
+// the same content, author and language. The returned slice has one entry per
+// quote: nil if it was created or ErrDuplicateQuote if it was skipped.
+// In atomic mode all the quotes are inserted in a single transaction,
+// otherwise each quote is inserted on its own: the quotes before one the
+// database fails on are already in, so its error is recorded in its entry
+// and the following quotes are still tried.
+func (q QuoteModel) InsertBatch(ctx context.Context, quotes []*Quote, atomic bool) ([]error, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.InsertBatch")
+	defer span.End()
+	query := `
+	INSERT INTO qod (content, author, language)
+	SELECT $1, $2, $3
+	WHERE NOT EXISTS (SELECT 1 FROM qod WHERE content = $1 AND author = $2 AND language = $3)
+	RETURNING id, score, votes, status, created_at, version
+	`
+	// a batch can be a lot bigger than a single quote
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var tx *sql.Tx
+	if atomic {
+		var err error
+		tx, err = q.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		// this is a no-op once the transaction has been committed
+		defer tx.Rollback()
+	}
+
+	results := make([]error, len(quotes))
+	for i, quote := range quotes {
+		args := []any{quote.Content, quote.Author, quote.Language}
+		var row *sql.Row
+		if tx != nil {
+			row = tx.QueryRowContext(ctx, query, args...)
+		} else {
+			row = q.DB.QueryRowContext(ctx, query, args...)
+		}
+		err := row.Scan(&quote.ID, &quote.Score, &quote.Votes, &quote.Status, &quote.CreatedAt, &quote.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				results[i] = ErrDuplicateQuote
+			case tx == nil:
+				results[i] = err
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	if tx != nil {
+		err := tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// Get a specific quote based on its ID, whatever its status
+func (q QuoteModel) Get(ctx context.Context, id int64) (*Quote, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.Get")
+	defer span.End()
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-	SELECT id, content, author, created_at, version
+	// the reads that have to see the latest writes skip the cache, the
+	// notification of the change may still be on its way
+	var generation uint64
+	cached := q.Cache != nil && !usesPrimary(ctx)
+	if cached {
+		quote, found := cacheLookup(q.Cache, q.Cache.quotes, id)
+		span.SetAttributes(tracing.Bool("cache.hit", found))
+		if found {
+			return quote, nil
+		}
+		generation, cached = q.Cache.begin(q.Replicas.lag())
+	}
+	query := fmt.Sprintf(`
+	SELECT %s
 	FROM qod
-	WHERE id = $1`
+	WHERE id = $1`, quoteColumns)
 
 	var quote Quote
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := q.DB.QueryRowContext(ctx, query, id).Scan(
-		&quote.ID,
-		&quote.Content,
-		&quote.Author,
-		&quote.CreatedAt,
-		&quote.Version)
+	err := q.read(ctx, func(ctx context.Context, db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, id).Scan(quote.destinations()...)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -71,38 +260,84 @@ func (q QuoteModel) Get(id int64) (*Quote, error) {
 			return nil, err
 		}
 	}
+	if cached {
+		cacheAdd(q.Cache, q.Cache.quotes, id, &quote, generation)
+	}
 	return &quote, nil
 }
 
-// update a specific quote based on its ID
-func (q QuoteModel) Update(quote *Quote) error {
+// update a specific quote based on its ID. The edit is recorded in the
+// moderation log in the same transaction, so that the trail has no gaps
+func (q QuoteModel) Update(ctx context.Context, quote *Quote, moderatorID int64) error {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.Update")
+	defer span.End()
 	query := `
 	UPDATE qod
-	SET content = $1, author = $2, version = version + 1
-	WHERE id = $3
+	SET content = $1, author = $2, language = $3, version = version + 1
+	WHERE id = $4
 	RETURNING version`
 	args := []any{
 		quote.Content,
 		quote.Author,
+		quote.Language,
 		quote.ID,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	return q.DB.QueryRowContext(ctx, query, args...).Scan(&quote.Version)
+
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&quote.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "qod_translation_language_idx"):
+			return ErrDuplicateTranslation
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	err = logModeration(ctx, tx, quote.ID, &moderatorID, ModerationEdit, "")
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	// the notification may come after the writer reads the quote back
+	q.Cache.Invalidate(quote.ID)
+	return nil
 }
 
-// delete a specific quote based on its ID
-func (q QuoteModel) Delete(id int64) error {
+// delete a specific quote based on its ID, recording it in the
+// moderation log in the same transaction
+func (q QuoteModel) Delete(ctx context.Context, id int64, moderatorID int64) error {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.Delete")
+	defer span.End()
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
 	DELETE FROM qod
 	WHERE id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	result, err := q.DB.ExecContext(ctx, query, id)
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -113,52 +348,365 @@ func (q QuoteModel) Delete(id int64) error {
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
+	err = logModeration(ctx, tx, id, &moderatorID, ModerationDelete, "")
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	q.Cache.Invalidate(id)
 	return nil
 }
 
 // Get all the quotes
-func (q QuoteModel) GetAll(content string, author string, filters Filters) ([]*Quote, Metadata, error) {
+func (q QuoteModel) GetAll(ctx context.Context, criteria QuoteCriteria, filters Filters) ([]*Quote, Metadata, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.GetAll")
+	defer span.End()
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, content, author, created_at, version
+	SELECT COUNT(*) OVER(), %s
 	FROM qod
-	WHERE (to_tsvector('simple', content) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (to_tsvector('simple', author) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	WHERE %s
 	 ORDER BY %s %s, id ASC 
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	LIMIT $5 OFFSET $6`, quoteColumns, quoteCriteriaClause, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	rows, err := q.DB.QueryContext(ctx, query, content, author, filters.limit(), filters.offset())
+	args := append(criteria.args(), filters.limit(), filters.offset())
+	totalRecords := 0
+	var quotes []*Quote
+	err := q.read(ctx, func(ctx context.Context, db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		quotes = nil
+		for rows.Next() {
+			var quote Quote
+			err := rows.Scan(append([]any{&totalRecords}, quote.destinations()...)...)
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, &quote)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, Metadata{}, err
 	}
-	defer rows.Close()
-	totalRecords := 0
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return quotes, metadata, nil
+}
+
+// Pass every quote matching the criteria to fn, in id order.
+// Unlike GetAll the quotes are read through a server-side cursor a
+// batch at a time, so the whole result never has to be held in memory.
+// The caller's context controls how long the export may run for.
+func (q QuoteModel) Export(ctx context.Context, criteria QuoteCriteria, fn func(*Quote) error) error {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.Export")
+	defer span.End()
+	const batchSize = 500
+
+	// the quotes go out as they are read, so only the start of the export
+	// can be tried again on the primary
+	tx, err := q.beginRead(ctx)
+	if err != nil {
+		return err
+	}
+	// we never write anything, so we can always roll back
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+	DECLARE qod_export NO SCROLL CURSOR FOR
+	SELECT %s
+	FROM qod
+	WHERE %s
+	ORDER BY id`, quoteColumns, quoteCriteriaClause)
+	_, err = tx.ExecContext(ctx, query, criteria.args()...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM qod_export`, batchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			var quote Quote
+			err := rows.Scan(quote.destinations()...)
+			if err == nil {
+				err = fn(&quote)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		// the cursor is exhausted
+		if fetched < batchSize {
+			return nil
+		}
+	}
+}
+
+// Get up to count distinct random quotes matching the criteria.
+// ORDER BY random() needs to read and sort every matching row, so instead
+// we pick random ids between the smallest and the largest matching id and
+// take the first matching quote at or after each of them (wrapping around
+// to the start when we run off the end). That is an index lookup on the id,
+// but with a text search the rows it walks over still have to be checked
+// against the criteria. All the picks of a round are looked up in one query;
+// picks that land on the same quote are tried again in the next round, and
+// the quotes are then fetched in one go.
+// Quotes that follow a gap in the ids are a little more likely to be
+// picked, which is good enough for our purposes.
+// When a seed is provided, the same seed over the same data always gives
+// back the same quotes.
+func (q QuoteModel) GetRandom(ctx context.Context, criteria QuoteCriteria, count int, seed *uint64) ([]*Quote, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.GetRandom")
+	defer span.End()
+
+	// find the range of ids we can pick from
+	rangeQuery := fmt.Sprintf(`
+	SELECT MIN(id), MAX(id)
+	FROM qod
+	WHERE %s`, quoteCriteriaClause)
+	// $5 are the picked ids and $6 the ids we already have. NULL when
+	// every matching quote has been taken
+	lookup := fmt.Sprintf(`
+	SELECT COALESCE(
+		(SELECT id FROM qod WHERE %[1]s AND id >= picks.pick AND NOT (id = ANY($6)) ORDER BY id LIMIT 1),
+		-- wrap around to the start of the range
+		(SELECT id FROM qod WHERE %[1]s AND NOT (id = ANY($6)) ORDER BY id LIMIT 1))
+	FROM unnest($5::bigint[]) WITH ORDINALITY AS picks(pick, n)
+	ORDER BY picks.n`, quoteCriteriaClause)
+	fetch := fmt.Sprintf(`
+	SELECT %s
+	FROM qod
+	WHERE id = ANY($1)`, quoteColumns)
+
 	var quotes []*Quote
+	err := q.read(ctx, func(ctx context.Context, db *sql.DB) error {
+		// a seed gives the same quotes however many times it is tried
+		var rng *rand.Rand
+		if seed != nil {
+			rng = rand.New(rand.NewPCG(*seed, *seed))
+		} else {
+			rng = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		}
+		quotes = []*Quote{}
+
+		var minID, maxID sql.NullInt64
+		err := db.QueryRowContext(ctx, rangeQuery, criteria.args()...).Scan(&minID, &maxID)
+		if err != nil || !minID.Valid {
+			return err
+		}
+
+		// not nil: pq sends a nil slice as NULL, and id = ANY(NULL) is never
+		// false, so NOT (id = ANY($6)) would match nothing at all
+		picked := []int64{}
+		for len(picked) < count {
+			picks := make([]int64, count-len(picked))
+			for i := range picks {
+				picks[i] = minID.Int64 + rng.Int64N(maxID.Int64-minID.Int64+1)
+			}
+			found, err := randomQuoteIDs(ctx, db, lookup, append(criteria.args(), pq.Array(picks), pq.Array(picked)))
+			if err != nil {
+				return err
+			}
+			// every matching quote has already been picked
+			if len(found) == 0 {
+				break
+			}
+			for _, id := range found {
+				if !slices.Contains(picked, id) {
+					picked = append(picked, id)
+				}
+			}
+		}
+		if len(picked) == 0 {
+			return nil
+		}
+
+		rows, err := db.QueryContext(ctx, fetch, pq.Array(picked))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		byID := make(map[int64]*Quote, len(picked))
+		for rows.Next() {
+			var quote Quote
+			err := rows.Scan(quote.destinations()...)
+			if err != nil {
+				return err
+			}
+			byID[quote.ID] = &quote
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// in the order they were picked, a quote deleted in the meantime
+		// is left out
+		for _, id := range picked {
+			if quote, ok := byID[id]; ok {
+				quotes = append(quotes, quote)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// the ids the lookup query of GetRandom found for its picks, leaving out
+// the picks for which there was nothing left
+func randomQuoteIDs(ctx context.Context, db *sql.DB, query string, args []any) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
 	for rows.Next() {
-		var quote Quote
-		err := rows.Scan(&totalRecords,
-			&quote.ID,
-			&quote.Content,
-			&quote.Author,
-			&quote.CreatedAt,
-			&quote.Version)
+		var id sql.NullInt64
+		err := rows.Scan(&id)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, err
+		}
+		if id.Valid {
+			ids = append(ids, id.Int64)
 		}
-		quotes = append(quotes, &quote)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+	return ids, rows.Err()
+}
+
+// Get the other versions of a quote: its original and the other
+// translations of that original, or its translations if it is an original
+func (q QuoteModel) GetTranslations(ctx context.Context, id int64) ([]*Quote, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.GetTranslations")
+	defer span.End()
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM qod
+	WHERE COALESCE(original_id, id) = (
+		SELECT COALESCE(original_id, id) FROM qod WHERE id = $1
+	)
+	AND id <> $1
+	AND status = 'approved'
+	ORDER BY original_id NULLS FIRST, language, id`, quoteColumns)
+
+	var quotes []*Quote
+	err := q.read(ctx, func(ctx context.Context, db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		quotes = []*Quote{}
+		for rows.Next() {
+			var quote Quote
+			err := rows.Scan(quote.destinations()...)
+			if err != nil {
+				return err
+			}
+			quotes = append(quotes, &quote)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	return quotes, metadata, nil
+	return quotes, nil
+}
+
+// Get the quote of the day. The date picks one of the original quotes
+// (translations are found with GetTranslations), so everyone gets the
+// same quote all day long
+func (q QuoteModel) GetDaily(ctx context.Context, day time.Time) (*Quote, error) {
+	ctx, span := startQuerySpan(ctx, "QuoteModel.GetDaily")
+	defer span.End()
+	key := day.UTC().Format("2006-01-02")
+	var generation uint64
+	cached := q.Cache != nil && !usesPrimary(ctx)
+	if cached {
+		quote, found := cacheLookup(q.Cache, q.Cache.daily, key)
+		span.SetAttributes(tracing.Bool("cache.hit", found))
+		if found {
+			return quote, nil
+		}
+		generation, cached = q.Cache.begin(q.Replicas.lag())
+	}
+
+	countQuery := `
+	SELECT COUNT(*)
+	FROM qod
+	WHERE original_id IS NULL AND status = 'approved'`
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM qod
+	WHERE original_id IS NULL AND status = 'approved'
+	ORDER BY id
+	OFFSET $1
+	LIMIT 1`, quoteColumns)
+
+	var quote Quote
+	err := q.read(ctx, func(ctx context.Context, db *sql.DB) error {
+		var count int64
+		err := db.QueryRowContext(ctx, countQuery).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrRecordNotFound
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		offset := h.Sum64() % uint64(count)
+		return db.QueryRowContext(ctx, query, int64(offset)).Scan(quote.destinations()...)
+	})
+	if err != nil {
+		switch {
+		// a quote was deleted between the two queries
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if cached {
+		cacheAdd(q.Cache, q.Cache.daily, key, &quote, generation)
+	}
+	return &quote, nil
 }
 
+// the longest content and author a quote may have, in characters
+type QuoteLimits struct {
+	ContentLength int
+	AuthorLength  int
+}
+
+// the limits used unless a deployment configures its own
+var DefaultQuoteLimits = QuoteLimits{ContentLength: 100, AuthorLength: 25}
+
 // Create a function that performs the validation checks
-func ValidateQuote(v *validator.Validator, quote *Quote) {
-	v.Check(quote.Content != "", "content", "must be provided")
-	v.Check(quote.Author != "", "author", "must be provided")
-	v.Check(len(quote.Content) <= 100, "content", "must not be more than 100 bytes long")
-	v.Check(len(quote.Author) <= 25, "author", "must not be more than 25 bytes long")
+// The quote should have gone through NormalizeQuote first
+func ValidateQuote(v *validator.Validator, quote *Quote, limits QuoteLimits) {
+	v.Required("content", quote.Content)
+	v.Required("author", quote.Author)
+	v.MaxLength("content", quote.Content, limits.ContentLength)
+	v.MaxLength("author", quote.Author, limits.AuthorLength)
+	v.Required("language", quote.Language)
+	v.CheckCode(quote.Language == "" || ValidLanguageTag(quote.Language), "language",
+		validator.CodeInvalidFormat, "must be a valid BCP 47 language tag", nil)
 }
@@ -0,0 +1,153 @@
+// Filename: internal/data/quotes_test.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// a connection that answers every query with what query returns
+type queryConn struct {
+	execConn
+	query func(args []driver.NamedValue) ([]string, [][]driver.Value, error)
+}
+
+func (c queryConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, values, err := c.query(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, values: values}, nil
+}
+
+type queryConnector struct {
+	conn queryConn
+}
+
+func (c queryConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c queryConnector) Driver() driver.Driver                        { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// a quote the database fails on is reported, and the next ones are
+// still inserted
+func TestInsertBatchPartial(t *testing.T) {
+	errBroken := errors.New("broken")
+	var id int64
+	db := sql.OpenDB(queryConnector{queryConn{query: func(args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		switch args[0].Value {
+		case "broken":
+			return nil, nil, errBroken
+		case "duplicate":
+			return []string{"id"}, nil, nil
+		}
+		id++
+		return []string{"id", "score", "votes", "status", "created_at", "version"},
+			[][]driver.Value{{id, 3.0, int64(0), QuoteStatusApproved, time.Now(), int64(1)}}, nil
+	}}})
+	defer db.Close()
+
+	quotes := []*Quote{{Content: "first"}, {Content: "broken"}, {Content: "duplicate"}, {Content: "last"}}
+	results, err := QuoteModel{DB: db}.InsertBatch(context.Background(), quotes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []error{nil, errBroken, ErrDuplicateQuote, nil}
+	for i := range want {
+		if !errors.Is(results[i], want[i]) {
+			t.Errorf("result %d = %v, want %v", i, results[i], want[i])
+		}
+	}
+	if quotes[0].ID != 1 || quotes[3].ID != 2 {
+		t.Errorf("ids = %d, %d; want 1, 2", quotes[0].ID, quotes[3].ID)
+	}
+}
+
+// who submitted a quote and why it was rejected is only for the moderators
+func TestQuoteJSON(t *testing.T) {
+	submitter := int64(7)
+	quote := &Quote{ID: 1, Content: "Be kind.", Status: QuoteStatusRejected, SubmittedBy: &submitter,
+		RejectionReason: "duplicate", Version: 2}
+
+	public, err := json.Marshal(quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	err = json.Unmarshal(public, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"submitted_by", "rejection_reason"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("the public JSON has %s: %s", key, public)
+		}
+	}
+
+	moderated, err := json.Marshal(quote.Moderated())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = nil
+	err = json.Unmarshal(moderated, &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"id": 1.0, "content": "Be kind.", "status": QuoteStatusRejected, "version": 2.0,
+		"submitted_by": 7.0, "rejection_reason": "duplicate"}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v in %s", key, fields[key], value, moderated)
+		}
+	}
+}
+
+// a deletion and its moderation log entry are committed together, or
+// not at all
+func TestDeleteLogsModeration(t *testing.T) {
+	tests := []struct {
+		name         string
+		rowsAffected int64
+		want         []string
+	}{
+		{"deleted", 1, []string{
+			"DELETE FROM qod WHERE id = $1",
+			"INSERT INTO moderation_log (quote_id, moderator_id, action, reason) VALUES ($1, $2, $3, $4)",
+			"COMMIT",
+		}},
+		{"not found", 0, []string{"DELETE FROM qod WHERE id = $1", "ROLLBACK"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			db := sql.OpenDB(execConnector{execConn{rowsAffected: tt.rowsAffected, log: &log}})
+			defer db.Close()
+			QuoteModel{DB: db}.Delete(context.Background(), 7, 1)
+			if !slices.Equal(log, tt.want) {
+				t.Errorf("statements = %q, want %q", log, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Filename: internal/data/replicas.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Replicas spreads the reads of the models over the read replicas of
+// the database, in turn. A replica that can't be reached, or that is
+// further behind the primary than maxLag, is left out until it catches up.
+// With no replica to read from the reads go to the primary
+type Replicas struct {
+	maxLag   time.Duration
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+type replica struct {
+	name string
+	db   *sql.DB
+
+	mu      sync.Mutex
+	healthy bool
+	lag     time.Duration
+	err     error
+}
+
+// ReplicaStatus is what the last check found out about a replica
+type ReplicaStatus struct {
+	Name    string
+	Healthy bool
+	Lag     time.Duration
+	Err     error
+}
+
+// NewReplicas takes the connection pools of the replicas. They are
+// named replica1, replica2... in the order given, as the DSNs hold secrets.
+// None of them is read from until it has been checked
+func NewReplicas(dbs []*sql.DB, maxLag time.Duration) *Replicas {
+	r := &Replicas{maxLag: maxLag}
+	for i, db := range dbs {
+		r.replicas = append(r.replicas, &replica{name: fmt.Sprintf("replica%d", i+1), db: db})
+	}
+	return r
+}
+
+// the context key of the reads that have to go to the primary
+type primaryContextKey struct{}
+
+// WithPrimary sends the reads made with the context to the primary,
+// e.g. those that have to see what the request (or client) just wrote
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func usesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryContextKey{}).(bool)
+	return primary
+}
+
+// how far behind the primary a read may be, zero with no replicas
+func (r *Replicas) lag() time.Duration {
+	if r == nil || len(r.replicas) == 0 {
+		return 0
+	}
+	return r.maxLag
+}
+
+// pick the next healthy replica, nil when the read has to go to the primary
+func (r *Replicas) pick(ctx context.Context) *replica {
+	if r == nil || len(r.replicas) == 0 || usesPrimary(ctx) {
+		return nil
+	}
+	start := r.next.Add(1)
+	for i := range uint64(len(r.replicas)) {
+		replica := r.replicas[(start+i)%uint64(len(r.replicas))]
+		replica.mu.Lock()
+		healthy := replica.healthy
+		replica.mu.Unlock()
+		if healthy {
+			return replica
+		}
+	}
+	return nil
+}
+
+// leave the replica out until the next check finds it working
+func (r *replica) eject(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthy = false
+	r.err = fmt.Errorf("read failed: %w", err)
+}
+
+// reports if err is about reaching the database rather than about the
+// query, so that the query can be run somewhere else
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netError net.Error
+	var pqError *pq.Error
+	switch {
+	case errors.Is(err, ErrDatabaseUnavailable):
+		return true
+	// a query running out of time says nothing about the connection, and
+	// context.DeadlineExceeded passes for a net.Error
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netError):
+		return true
+	case errors.As(err, &pqError):
+		// connection exceptions, and the server shutting down or starting up
+		return pqError.Code.Class() == "08" || strings.HasPrefix(string(pqError.Code), "57P")
+	}
+	return false
+}
+
+// how far behind the primary the replica is. When it has replayed
+// everything it received there is nothing to wait for, however long ago
+// the last write was
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+// check every replica once
+func (r *Replicas) check(ctx context.Context) {
+	for _, replica := range r.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, time.Second)
+		var seconds float64
+		err := replica.db.QueryRowContext(checkCtx, replicaLagQuery).Scan(&seconds)
+		cancel()
+		lag := time.Duration(seconds * float64(time.Second))
+		if err == nil && lag > r.maxLag {
+			err = fmt.Errorf("%s behind the primary", lag.Round(time.Millisecond))
+		}
+
+		replica.mu.Lock()
+		replica.healthy = err == nil
+		replica.lag = lag
+		replica.err = err
+		replica.mu.Unlock()
+	}
+}
+
+// Run checks the replicas every interval until ctx is done
+func (r *Replicas) Run(ctx context.Context, interval time.Duration) {
+	if r == nil || len(r.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status reports what the last check found out about every replica
+func (r *Replicas) Status() []ReplicaStatus {
+	if r == nil {
+		return nil
+	}
+	statuses := make([]ReplicaStatus, len(r.replicas))
+	for i, replica := range r.replicas {
+		replica.mu.Lock()
+		statuses[i] = ReplicaStatus{Name: replica.name, Healthy: replica.healthy, Lag: replica.lag, Err: replica.err}
+		if !replica.healthy && replica.err == nil {
+			statuses[i].Err = errors.New("not checked yet")
+		}
+		replica.mu.Unlock()
+	}
+	return statuses
+}
+
+// Close closes the connection pools of the replicas
+func (r *Replicas) Close() error {
+	if r == nil {
+		return nil
+	}
+	var errs []error
+	for _, replica := range r.replicas {
+		errs = append(errs, replica.db.Close())
+	}
+	return errors.Join(errs...)
+}
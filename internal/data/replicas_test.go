@@ -0,0 +1,77 @@
+// Filename: internal/data/replicas_test.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// a read on a replica that can't be reached is run again on the primary,
+// and the replica is left out
+func TestReadFallsBackToPrimary(t *testing.T) {
+	replicaStub := &stubConnector{dial: func(context.Context) error { return errRefused }}
+	errPrimary := errors.New("primary reached")
+	primaryStub := &stubConnector{dial: func(context.Context) error { return errPrimary }}
+	replicaDB := sql.OpenDB(breakerConnector{Connector: replicaStub, breaker: NewCircuitBreaker(5, time.Second)})
+	primaryDB := sql.OpenDB(breakerConnector{Connector: primaryStub, breaker: NewCircuitBreaker(5, time.Second)})
+	defer replicaDB.Close()
+	defer primaryDB.Close()
+
+	replicas := NewReplicas([]*sql.DB{replicaDB}, time.Second)
+	replicas.replicas[0].healthy = true
+	model := QuoteModel{DB: primaryDB, Replicas: replicas}
+
+	_, err := model.GetTranslations(context.Background(), 1)
+	if !errors.Is(err, errPrimary) {
+		t.Fatalf("error = %v, want the primary's", err)
+	}
+	if replicaStub.dials != 1 || primaryStub.dials != 1 {
+		t.Errorf("replica dials = %d, primary dials = %d; want 1 each", replicaStub.dials, primaryStub.dials)
+	}
+	status := replicas.Status()[0]
+	if status.Healthy || status.Err == nil || !strings.Contains(status.Err.Error(), "read failed") {
+		t.Errorf("replica status = %+v, want it left out", status)
+	}
+
+	// the next read goes straight to the primary
+	_, err = model.GetTranslations(context.Background(), 1)
+	if !errors.Is(err, errPrimary) || replicaStub.dials != 1 {
+		t.Errorf("error = %v, replica dials = %d; want the primary's error, 1", err, replicaStub.dials)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{sql.ErrNoRows, false},
+		{ErrRecordNotFound, false},
+		{context.DeadlineExceeded, false},
+		{ErrDatabaseUnavailable, true},
+		{fmt.Errorf("%w: %w", ErrDatabaseUnavailable, errRefused), true},
+		{driver.ErrBadConn, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true},
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "57P01"}, true},  // admin_shutdown
+		{&pq.Error{Code: "57P03"}, true},  // cannot_connect_now
+		{&pq.Error{Code: "57014"}, false}, // query_canceled
+		{&pq.Error{Code: "42P01"}, false}, // undefined_table
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+	}
+	for _, tt := range tests {
+		if got := isConnectionError(tt.err); got != tt.want {
+			t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Filename: internal/data/reports.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// why a reader reports a quote
+var ReportReasons = []string{"misattributed", "offensive", "duplicate", "typo", "other"}
+
+// how a moderator resolves a report on its own. Approving or rejecting
+// the quote resolves its reports with the quote's new status
+const (
+	ReportDismissed = "dismissed" // nothing wrong with the quote
+	ReportFixed     = "fixed"     // the quote was edited
+)
+
+// A Report is a reader telling us something is wrong with a quote
+type Report struct {
+	ID         int64      `json:"id"`
+	QuoteID    int64      `json:"quote_id"`
+	UserID     int64      `json:"user_id"`
+	Reason     string     `json:"reason"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy *int64     `json:"resolved_by,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+// the columns every query returning reports selects
+const reportColumns = `id, quote_id, user_id, reason, note, created_at, resolved_at, resolved_by, resolution`
+
+// where to scan a row of reportColumns into
+func (report *Report) destinations() []any {
+	return []any{
+		&report.ID,
+		&report.QuoteID,
+		&report.UserID,
+		&report.Reason,
+		&report.Note,
+		&report.CreatedAt,
+		&report.ResolvedAt,
+		&report.ResolvedBy,
+		&report.Resolution,
+	}
+}
+
+// Check a new report. "other" needs a note to tell us what is wrong
+func ValidateReport(v *validator.Validator, report *Report) {
+	v.OneOf("reason", report.Reason, ReportReasons...)
+	if report.Reason == "other" {
+		v.Required("note", report.Note)
+	}
+	v.MaxLength("note", report.Note, 500)
+}
+
+// ReportFilters narrows down the reports a moderator looks at
+type ReportFilters struct {
+	Status  string // open, resolved or all
+	QuoteID int64  // 0 for every quote
+}
+
+// A ReportModel expects a connection pool
+type ReportModel struct {
+	DB *sql.DB
+	// the cached quotes to drop after a change, can be nil
+	Cache *QuoteCache
+}
+
+// Insert a report. Once a quote has threshold open reports, it is
+// taken out of the curated set and put back in the moderation queue;
+// the returned bool tells if this report did that. A threshold of 0
+// never hides a quote
+func (m ReportModel) Insert(report *Report, threshold int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// the lock keeps two reports from both deciding to hide the quote
+	var status string
+	query := `
+	SELECT status
+	FROM qod
+	WHERE id = $1
+	FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, report.QuoteID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	query = `
+	INSERT INTO quote_reports (quote_id, user_id, reason, note)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+	args := []any{report.QuoteID, report.UserID, report.Reason, report.Note}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err, "quote_reports_open_idx") {
+			return false, ErrDuplicateReport
+		}
+		return false, err
+	}
+
+	var open int
+	query = `
+	SELECT COUNT(*)
+	FROM quote_reports
+	WHERE quote_id = $1 AND resolved_at IS NULL`
+	err = tx.QueryRowContext(ctx, query, report.QuoteID).Scan(&open)
+	if err != nil {
+		return false, err
+	}
+	hidden := false
+	if threshold > 0 && status == QuoteStatusApproved && open >= threshold {
+		query = `
+		UPDATE qod
+		SET status = $1, version = version + 1
+		WHERE id = $2`
+		_, err = tx.ExecContext(ctx, query, QuoteStatusPending, report.QuoteID)
+		if err != nil {
+			return false, err
+		}
+		reason := fmt.Sprintf("reported %d times", open)
+		err = logModeration(ctx, tx, report.QuoteID, nil, ModerationHide, reason)
+		if err != nil {
+			return false, err
+		}
+		hidden = true
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	if hidden {
+		m.Cache.Invalidate(report.QuoteID)
+	}
+	return hidden, nil
+}
+
+// Get a specific report based on its ID
+func (m ReportModel) Get(id int64) (*Report, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM quote_reports
+	WHERE id = $1`, reportColumns)
+
+	var report Report
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(report.destinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &report, nil
+}
+
+// Get a page of reports, the oldest first
+func (m ReportModel) GetAll(reportFilters ReportFilters, filters Filters) ([]*Report, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), %s
+	FROM quote_reports
+	WHERE (quote_id = $1 OR $1 = 0)
+	AND ($2 = 'all' OR ($2 = 'open') = (resolved_at IS NULL))
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, reportColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := []any{reportFilters.QuoteID, reportFilters.Status, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	reports := []*Report{}
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(append([]any{&totalRecords}, report.destinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reports = append(reports, &report)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reports, metadata, nil
+}
+
+// Resolve an open report
+func (m ReportModel) Resolve(report *Report, moderatorID int64, resolution string) error {
+	query := `
+	UPDATE quote_reports
+	SET resolved_at = NOW(), resolved_by = $1, resolution = $2
+	WHERE id = $3 AND resolved_at IS NULL
+	RETURNING resolved_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, moderatorID, resolution, report.ID).Scan(&report.ResolvedAt)
+	if err != nil {
+		switch {
+		// it was resolved in the meantime
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	report.ResolvedBy = &moderatorID
+	report.Resolution = resolution
+	return nil
+}
+
+// resolve every open report of a quote, as part of a moderation decision
+func resolveQuoteReports(ctx context.Context, db execer, quoteID int64, moderatorID int64, resolution string) error {
+	query := `
+	UPDATE quote_reports
+	SET resolved_at = NOW(), resolved_by = $1, resolution = $2
+	WHERE quote_id = $3 AND resolved_at IS NULL`
+	_, err := db.ExecContext(ctx, query, moderatorID, resolution, quoteID)
+	return err
+}
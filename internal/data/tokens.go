@@ -0,0 +1,86 @@
+// Filename: internal/data/tokens.go
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// the scopes a token can be issued for
+const ScopeAuthentication = "authentication"
+
+// A Token lets a user make requests without sending their password.
+// Only the hash is stored, the plaintext is shown to the client once
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// create a token with 128 bits of randomness
+func generateToken(userID int64, ttl time.Duration, scope string) *Token {
+	token := &Token{
+		Plaintext: rand.Text(),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+	return token
+}
+
+// Check that the client sent something that looks like one of our tokens
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Required("token", tokenPlaintext)
+	_, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(tokenPlaintext)
+	v.CheckCode(len(tokenPlaintext) == 26 && err == nil, "token",
+		validator.CodeInvalidFormat, "must be 26 characters long", nil)
+}
+
+// Setup the struct
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Create a new token for a user and save it
+func (t TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+	err := t.Insert(token)
+	return token, err
+}
+
+// Insert a token into the database
+func (t TokenModel) Insert(token *Token) error {
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete all the tokens of a user for a scope, e.g. to log them out everywhere
+func (t TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+        DELETE FROM tokens
+        WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
@@ -0,0 +1,14 @@
+// Filename: internal/data/tracing.go
+package data
+
+import (
+	"context"
+
+	"github.com/amilcar-vasquez/qod/internal/tracing"
+)
+
+// time a model method in a span of the request's trace, so a slow
+// request shows how much of it was spent waiting on PostgreSQL
+func startQuerySpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, name, tracing.KindClient, tracing.String("db.system", "postgresql"))
+}
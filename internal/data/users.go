@@ -3,6 +3,7 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"github.com/amilcar-vasquez/qod/internal/validator"
@@ -20,6 +21,14 @@ type User struct {
 	Version   int       `json:"-"`
 }
 
+// the user of a request that didn't authenticate
+var AnonymousUser = &User{}
+
+// check if a user is the anonymous user
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
 // define the password type (the plaintext + hashed password)
 // lowercase because we do not want it to be public
 type password struct {
@@ -57,22 +66,25 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // Validate the email address
 // We will implement validator.Matches() later
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email",
-		"must be a valid email address")
+	v.Required("email", email)
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email",
+		validator.CodeInvalidFormat, "must be a valid email address", nil)
 }
 
 // Check that a valid password is provided
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Required("password", password)
+	// bcrypt works on bytes, so these limits are in bytes too
+	v.CheckCode(len(password) >= 8, "password", validator.CodeMinLength,
+		"must be at least 8 bytes long", validator.Params{"min": 8})
+	v.CheckCode(len(password) <= 72, "password", validator.CodeMaxLength,
+		"must not be more than 72 bytes long", validator.Params{"max": 72})
 }
 
 // Validate a user
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Username != "", "username", "must be provided")
-	v.Check(len(user.Username) <= 200, "username", "must not be more than 200 bytes long")
+	v.Required("username", user.Username)
+	v.MaxLength("username", user.Username, 200)
 	// validate email for user
 	ValidateEmail(v, user.Email)
 	// validate the plain text password
@@ -95,7 +107,9 @@ type UserModel struct {
 }
 
 // Insert a new user into the database
-func (u UserModel) Insert(user *User) error {
+func (u UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, span := startQuerySpan(ctx, "UserModel.Insert")
+	defer span.End()
 	query := `
             INSERT INTO users (username, email, password_hash, activated) 
             VALUES ($1, $2, $3, $4)
@@ -103,7 +117,7 @@ func (u UserModel) Insert(user *User) error {
            `
 	args := []any{user.Username, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	// if an email address already exists we will get a pq error message
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -125,7 +139,9 @@ func (u UserModel) Insert(user *User) error {
 }
 
 // Get a user from the database based on their email provided
-func (u UserModel) GetByEmail(email string) (*User, error) {
+func (u UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := startQuerySpan(ctx, "UserModel.GetByEmail")
+	defer span.End()
 	query := `
        SELECT id, created_at, username, email, password_hash, activated, version
        FROM users
@@ -133,7 +149,7 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
       `
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := u.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -160,7 +176,9 @@ func (u UserModel) GetByEmail(email string) (*User, error) {
 // than what is was before the ran the query, it means
 // someone did a previous edit or is doing an edit, so
 // our query will fail and we would need to try again a bit later
-func (u UserModel) Update(user *User) error {
+func (u UserModel) Update(ctx context.Context, user *User) error {
+	ctx, span := startQuerySpan(ctx, "UserModel.Update")
+	defer span.End()
 	query := `
         UPDATE users 
         SET username = $1, email = $2, password_hash = $3,
@@ -176,7 +194,7 @@ func (u UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -195,3 +213,44 @@ func (u UserModel) Update(user *User) error {
 
 	return nil
 }
+
+// Get the user a token was issued to, as long as the token
+// has the right scope and hasn't expired
+func (u UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	ctx, span := startQuerySpan(ctx, "UserModel.GetForToken")
+	defer span.End()
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+        SELECT users.id, users.created_at, users.username, users.email,
+               users.password_hash, users.activated, users.version
+        FROM users
+        INNER JOIN tokens
+        ON users.id = tokens.user_id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3`
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := u.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
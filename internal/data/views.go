@@ -0,0 +1,109 @@
+// Filename: internal/data/views.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// how long the hourly view counts are kept, the longest
+// trending window has to fit in it
+const ViewRetention = 90 * 24 * time.Hour
+
+// A TrendingQuote is a quote with how much it was read lately
+type TrendingQuote struct {
+	Quote
+	Views int64   `json:"views"` // views within the window
+	Trend float64 `json:"trend"` // views, the older ones counting for less
+}
+
+// A ViewModel expects a connection pool
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Add view counts (by quote id) to the bucket of an hour. Views of
+// quotes that were deleted in the meantime are dropped
+func (m ViewModel) Add(hour time.Time, counts map[int64]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(counts))
+	views := make([]int64, 0, len(counts))
+	for id, count := range counts {
+		ids = append(ids, id)
+		views = append(views, count)
+	}
+	query := `
+	INSERT INTO quote_views_hourly (quote_id, hour, views)
+	SELECT counts.quote_id, $1, counts.views
+	FROM unnest($2::bigint[], $3::bigint[]) AS counts(quote_id, views)
+	WHERE EXISTS (SELECT 1 FROM qod WHERE id = counts.quote_id)
+	ON CONFLICT (quote_id, hour)
+	DO UPDATE SET views = quote_views_hourly.views + EXCLUDED.views`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hour.UTC().Truncate(time.Hour), pq.Array(ids), pq.Array(views))
+	return err
+}
+
+// Delete the view counts of the hours before a time
+func (m ViewModel) DeleteBefore(t time.Time) error {
+	query := `
+	DELETE FROM quote_views_hourly
+	WHERE hour < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, t)
+	return err
+}
+
+// Get the quotes matching the criteria that were read the most within
+// the window before now. A view loses half its weight every halfLife,
+// so a quote read a lot an hour ago beats one read a lot last week
+func (m ViewModel) GetTrending(criteria QuoteCriteria, window time.Duration, halfLife time.Duration,
+	limit int) ([]*TrendingQuote, error) {
+
+	// $5 is now, $6 the start of the window and $7 the half-life in seconds
+	query := fmt.Sprintf(`
+	SELECT %s, trending.views, trending.trend
+	FROM (
+		SELECT quote_id, SUM(views) AS views,
+		       SUM(views * exp(-ln(2) * extract(epoch FROM $5::timestamptz - hour) / $7::float8)) AS trend
+		FROM quote_views_hourly
+		WHERE hour >= $6
+		GROUP BY quote_id
+	) AS trending
+	INNER JOIN qod ON qod.id = trending.quote_id
+	WHERE %s
+	ORDER BY trending.trend DESC, id ASC
+	LIMIT $8`, quoteColumns, quoteCriteriaClause)
+
+	now := time.Now()
+	args := append(criteria.args(), now, now.Add(-window), halfLife.Seconds(), limit)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	quotes := []*TrendingQuote{}
+	for rows.Next() {
+		var quote TrendingQuote
+		err := rows.Scan(append(quote.destinations(), &quote.Views, &quote.Trend)...)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, &quote)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
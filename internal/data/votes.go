@@ -0,0 +1,146 @@
+// Filename: internal/data/votes.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/amilcar-vasquez/qod/internal/validator"
+)
+
+// A quote's score is a Bayesian average of its ratings: every quote starts
+// out with ratingPriorWeight imaginary votes of ratingPrior stars, so a
+// single 5-star vote moves it a little, but it takes a lot of them to
+// reach the top. The migration uses ratingPrior as the default score
+const (
+	ratingPrior       = 3.0
+	ratingPriorWeight = 5.0
+)
+
+// the lowest and highest rating of a vote, in stars
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Check the rating of a vote
+func ValidateRating(v *validator.Validator, rating int) {
+	v.Range("rating", rating, MinRating, MaxRating)
+}
+
+// A VoteModel expects a connection pool
+type VoteModel struct {
+	DB *sql.DB
+	// the cached quotes to drop after a change, can be nil
+	Cache *QuoteCache
+}
+
+// Set the rating a user gives a quote, replacing their previous vote.
+// The quote's score and vote count are updated to match
+func (m VoteModel) Upsert(userID int64, quote *Quote, rating int) error {
+	query := `
+	INSERT INTO quote_votes (user_id, quote_id, rating)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, quote_id)
+	DO UPDATE SET rating = EXCLUDED.rating, updated_at = NOW()`
+	return m.change(quote, query, userID, quote.ID, rating)
+}
+
+// Take back the vote of a user on a quote
+func (m VoteModel) Delete(userID int64, quote *Quote) error {
+	query := `
+	DELETE FROM quote_votes
+	WHERE user_id = $1 AND quote_id = $2`
+	return m.change(quote, query, userID, quote.ID)
+}
+
+// Get the rating a user gave a quote
+func (m VoteModel) Get(userID int64, quoteID int64) (int, error) {
+	query := `
+	SELECT rating
+	FROM quote_votes
+	WHERE user_id = $1 AND quote_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rating int
+	err := m.DB.QueryRowContext(ctx, query, userID, quoteID).Scan(&rating)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return rating, nil
+}
+
+// run a statement changing the votes of a quote and recompute the quote's
+// aggregates in the same transaction. The quote is locked first, so two
+// votes on the same quote can't compute the aggregates from stale totals
+func (m VoteModel) change(quote *Quote, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	lock := `
+	SELECT id
+	FROM qod
+	WHERE id = $1
+	FOR UPDATE`
+	err = tx.QueryRowContext(ctx, lock, quote.ID).Scan(&quote.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	aggregate := `
+	UPDATE qod
+	SET votes = totals.votes,
+	    rating_sum = totals.rating_sum,
+	    score = ($2::float8 * $3::float8 + totals.rating_sum) / ($3::float8 + totals.votes)
+	FROM (
+		SELECT COUNT(*) AS votes, COALESCE(SUM(rating), 0) AS rating_sum
+		FROM quote_votes
+		WHERE quote_id = $1
+	) AS totals
+	WHERE qod.id = $1
+	RETURNING qod.votes, qod.score`
+	err = tx.QueryRowContext(ctx, aggregate, quote.ID, ratingPrior, ratingPriorWeight).Scan(
+		&quote.Votes,
+		&quote.Score)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(quote.ID)
+	return nil
+}
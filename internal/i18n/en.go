@@ -0,0 +1,48 @@
+// Filename: internal/i18n/en.go
+package i18n
+
+var english = Catalog{
+	// validation errors, keyed by the validator codes
+	"invalid":        "is invalid",
+	"required":       "must be provided",
+	"min_length":     "must be at least {min} characters long",
+	"max_length":     "must not be more than {max} characters long",
+	"min_value":      "must be at least {min}",
+	"max_value":      "must be a maximum of {max}",
+	"not_permitted":  "must be one of {permitted}",
+	"invalid_format": "has an invalid format",
+	"invalid_url":    "must be a valid URL",
+	"not_integer":    "must be an integer value",
+	"not_unique":     "must not contain duplicate values ({duplicate})",
+	"already_exists": "is already in use",
+
+	// error responses, keyed by the problem codes
+	"problem.server_error.title":             "Internal server error",
+	"problem.server_error.detail":            "the server encountered a problem and could not process your request",
+	"problem.not_found.title":                "Resource not found",
+	"problem.not_found.detail":               "the requested resource could not be found",
+	"problem.method_not_allowed.title":       "Method not allowed",
+	"problem.method_not_allowed.detail":      "the {method} method is not supported for this resource",
+	"problem.bad_request.title":              "Bad request",
+	"problem.bad_request.detail":             "the request is invalid: {error}",
+	"problem.validation_failed.title":        "Validation failed",
+	"problem.validation_failed.detail":       "one or more fields failed validation",
+	"problem.rate_limited.title":             "Rate limit exceeded",
+	"problem.rate_limited.detail":            "rate limit exceeded",
+	"problem.edit_conflict.title":            "Edit conflict",
+	"problem.edit_conflict.detail":           "unable to update the record due to an edit conflict, please try again",
+	"problem.not_acceptable.title":           "Not acceptable",
+	"problem.not_acceptable.detail":          "the requested resource is only available as {types}",
+	"problem.invalid_credentials.title":      "Invalid credentials",
+	"problem.invalid_credentials.detail":     "invalid authentication credentials",
+	"problem.invalid_token.title":            "Invalid token",
+	"problem.invalid_token.detail":           "invalid or missing authentication token",
+	"problem.authentication_required.title":  "Authentication required",
+	"problem.authentication_required.detail": "you must be authenticated to access this resource",
+	"problem.forbidden.title":                "Forbidden",
+	"problem.forbidden.detail":               "you do not have permission to access this resource",
+	"problem.inactive_account.title":         "Account not activated",
+	"problem.inactive_account.detail":        "your account must be activated before you can log in",
+	"problem.database_unavailable.title":     "Service unavailable",
+	"problem.database_unavailable.detail":    "the database is unavailable right now, please try again later",
+}
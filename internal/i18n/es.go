@@ -0,0 +1,48 @@
+// Filename: internal/i18n/es.go
+package i18n
+
+var spanish = Catalog{
+	// validation errors, keyed by the validator codes
+	"invalid":        "no es válido",
+	"required":       "es obligatorio",
+	"min_length":     "debe tener al menos {min} caracteres",
+	"max_length":     "no debe tener más de {max} caracteres",
+	"min_value":      "debe ser como mínimo {min}",
+	"max_value":      "debe ser como máximo {max}",
+	"not_permitted":  "debe ser uno de {permitted}",
+	"invalid_format": "tiene un formato no válido",
+	"invalid_url":    "debe ser una URL válida",
+	"not_integer":    "debe ser un número entero",
+	"not_unique":     "no debe contener valores repetidos ({duplicate})",
+	"already_exists": "ya está en uso",
+
+	// error responses, keyed by the problem codes
+	"problem.server_error.title":             "Error interno del servidor",
+	"problem.server_error.detail":            "el servidor tuvo un problema y no pudo procesar su solicitud",
+	"problem.not_found.title":                "Recurso no encontrado",
+	"problem.not_found.detail":               "no se pudo encontrar el recurso solicitado",
+	"problem.method_not_allowed.title":       "Método no permitido",
+	"problem.method_not_allowed.detail":      "el método {method} no está permitido para este recurso",
+	"problem.bad_request.title":              "Solicitud incorrecta",
+	"problem.bad_request.detail":             "la solicitud no es válida: {error}",
+	"problem.validation_failed.title":        "Error de validación",
+	"problem.validation_failed.detail":       "uno o más campos no son válidos",
+	"problem.rate_limited.title":             "Límite de solicitudes excedido",
+	"problem.rate_limited.detail":            "se ha excedido el límite de solicitudes",
+	"problem.edit_conflict.title":            "Conflicto de edición",
+	"problem.edit_conflict.detail":           "no se pudo actualizar el registro debido a un conflicto de edición, inténtelo de nuevo",
+	"problem.not_acceptable.title":           "No aceptable",
+	"problem.not_acceptable.detail":          "el recurso solicitado solo está disponible como {types}",
+	"problem.invalid_credentials.title":      "Credenciales no válidas",
+	"problem.invalid_credentials.detail":     "las credenciales de autenticación no son válidas",
+	"problem.invalid_token.title":            "Token no válido",
+	"problem.invalid_token.detail":           "el token de autenticación no es válido o falta",
+	"problem.authentication_required.title":  "Autenticación requerida",
+	"problem.authentication_required.detail": "debe autenticarse para acceder a este recurso",
+	"problem.forbidden.title":                "Prohibido",
+	"problem.forbidden.detail":               "no tiene permiso para acceder a este recurso",
+	"problem.inactive_account.title":         "Cuenta no activada",
+	"problem.inactive_account.detail":        "debe activar su cuenta antes de iniciar sesión",
+	"problem.database_unavailable.title":     "Servicio no disponible",
+	"problem.database_unavailable.detail":    "la base de datos no está disponible en este momento, intente de nuevo más tarde",
+}
@@ -0,0 +1,124 @@
+// Filename: internal/i18n/i18n.go
+package i18n
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// the language we fall back to when we can't find a better match
+const DefaultLanguage = "en"
+
+// A Catalog maps a message key (a validator or problem code) to its
+// message. A message can refer to the parameters of the error by
+// name, e.g. "must not be more than {max} characters long"
+type Catalog map[string]string
+
+// the catalogs we ship, by language
+var catalogs = map[string]Catalog{
+	"en": english,
+	"es": spanish,
+}
+
+// the languages we support, the default one must come first since the
+// matcher falls back to it
+var supported = []string{"en", "es"}
+
+var matcher = func() language.Matcher {
+	tags := make([]language.Tag, len(supported))
+	for i, lang := range supported {
+		tags[i] = language.MustParse(lang)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// Languages returns the languages we have a catalog for
+func Languages() []string {
+	return supported
+}
+
+// Language picks the best supported language for an Accept-Language
+// header, falling back to English
+func Language(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLanguage
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLanguage
+	}
+	return supported[index]
+}
+
+// Translate returns the message for the key in the language, with the
+// parameters filled in. If the language has no such message, the
+// English one is used. The boolean is false if neither has it.
+func Translate(lang string, key string, params map[string]any) (string, bool) {
+	message, found := catalogs[lang][key]
+	if !found {
+		message, found = catalogs[DefaultLanguage][key]
+		if !found {
+			return "", false
+		}
+	}
+	for name, value := range params {
+		message = strings.ReplaceAll(message, "{"+name+"}", formatParam(value))
+	}
+	return message, true
+}
+
+// lists (e.g. the permitted values) are written out separated by commas
+func formatParam(value any) string {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+// Missing returns, for each language, the keys it has no message for
+func Missing(keys []string) map[string][]string {
+	missing := make(map[string][]string)
+	for _, lang := range supported {
+		for _, key := range keys {
+			if _, found := catalogs[lang][key]; !found {
+				missing[lang] = append(missing[lang], key)
+			}
+		}
+		sort.Strings(missing[lang])
+	}
+	for lang, keys := range missing {
+		if len(keys) == 0 {
+			delete(missing, lang)
+		}
+	}
+	return missing
+}
+
+// Best picks the language tag from available that best matches an
+// Accept-Language header. It returns false if none of them is a match
+func Best(acceptLanguage string, available []string) (int, bool) {
+	desired, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(desired) == 0 || len(available) == 0 {
+		return 0, false
+	}
+	tags := make([]language.Tag, len(available))
+	for i, tag := range available {
+		// a tag we can't parse can't match anything
+		tags[i], _ = language.Parse(tag)
+	}
+	_, index, confidence := language.NewMatcher(tags).Match(desired...)
+	if confidence == language.No {
+		return 0, false
+	}
+	return index, true
+}
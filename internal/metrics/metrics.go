@@ -0,0 +1,403 @@
+// Filename: internal/metrics/metrics.go
+
+// Package metrics keeps counters, gauges and histograms in memory and
+// writes them out in the Prometheus text exposition format (0.0.4).
+// It only does what qod needs, so we don't have to pull in the whole
+// Prometheus client.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the latency buckets (in seconds) of an HTTP API
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// anything that can write its samples to a scrape
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// A Registry holds the metrics of the application
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write every metric, in the order they were registered
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// the HELP and TYPE lines of a metric
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// a sample line, the labels are name/value pairs
+func writeSample(w *bufio.Writer, name string, labels []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// a float64 that can be added to from several goroutines
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if f.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(value float64) {
+	f.bits.Store(math.Float64bits(value))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// A Counter only goes up
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: a counter can't go down")
+	}
+	c.value.add(delta)
+}
+
+// A Gauge goes up and down
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.set(value)
+}
+
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+// A Histogram counts observations in buckets
+type Histogram struct {
+	upperBounds []float64
+	buckets     []atomic.Uint64 // not cumulative, summed up when written
+	count       atomic.Uint64
+	sum         atomicFloat
+}
+
+func newHistogram(upperBounds []float64) *Histogram {
+	return &Histogram{
+		upperBounds: upperBounds,
+		buckets:     make([]atomic.Uint64, len(upperBounds)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	i, _ := slices.BinarySearch(h.upperBounds, value)
+	if i < len(h.buckets) {
+		h.buckets[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(value)
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string, labels []string) {
+	var cumulative uint64
+	for i, upperBound := range h.upperBounds {
+		cumulative += h.buckets[i].Load()
+		writeSample(w, name+"_bucket", append(slices.Clone(labels), "le", formatFloat(upperBound)), float64(cumulative))
+	}
+	count := h.count.Load()
+	writeSample(w, name+"_bucket", append(slices.Clone(labels), "le", "+Inf"), float64(count))
+	writeSample(w, name+"_sum", labels, h.sum.load())
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// a family of metrics of the same name, one per set of label values
+type vec[T any] struct {
+	name     string
+	help     string
+	kind     string
+	labels   []string
+	newChild func() *T
+	mu       sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func newVec[T any](name string, help string, kind string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		labels:   labels,
+		newChild: newChild,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+	}
+}
+
+// get the metric with the label values, creating it the first time
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	v.values[key] = slices.Clone(values)
+	return child
+}
+
+// write the header and every child, sorted by their label values
+func (v *vec[T]) writeAll(w *bufio.Writer, fn func(child *T, labels []string)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	slices.Sort(keys)
+
+	writeHeader(w, v.name, v.help, v.kind)
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		labels := make([]string, 0, 2*len(values))
+		for i, value := range values {
+			labels = append(labels, v.labels[i], value)
+		}
+		fn(child, labels)
+	}
+}
+
+// CounterVec is a family of counters told apart by their labels
+type CounterVec struct {
+	*vec[Counter]
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeAll(w, func(counter *Counter, labels []string) {
+		writeSample(w, c.name, labels, counter.value.load())
+	})
+}
+
+// NewCounter is a counter without labels
+func (r *Registry) NewCounter(name string, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// GaugeVec is a family of gauges told apart by their labels
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values...)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeAll(w, func(gauge *Gauge, labels []string) {
+		writeSample(w, g.name, labels, gauge.value.load())
+	})
+}
+
+// NewGauge is a gauge without labels
+func (r *Registry) NewGauge(name string, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// HistogramVec is a family of histograms told apart by their labels
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Sorted(slices.Values(buckets))
+	h := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeAll(w, func(histogram *Histogram, labels []string) {
+		histogram.write(w, h.name, labels)
+	})
+}
+
+// a metric whose value is read when it is scraped
+type funcMetric struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	writeSample(w, f.name, nil, f.fn())
+}
+
+// NewGaugeFunc registers a gauge read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter read from fn on every scrape
+func (r *Registry) NewCounterFunc(name string, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+// the Go runtime metrics, read once per scrape
+type goCollector struct {
+	start time.Time
+}
+
+// RegisterGoCollector adds the usual go_* and process start time metrics
+func (r *Registry) RegisterGoCollector() {
+	r.register(&goCollector{start: time.Now()})
+}
+
+func (g *goCollector) write(w *bufio.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	gauges := []struct {
+		name  string
+		help  string
+		kind  string
+		value float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_threads", "Number of OS threads created.", "gauge", float64(threads())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge", float64(stats.Alloc)},
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(stats.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge", float64(stats.Sys)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(stats.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(stats.HeapObjects)},
+		{"go_memstats_mallocs_total", "Total number of mallocs.", "counter", float64(stats.Mallocs)},
+		{"go_memstats_frees_total", "Total number of frees.", "counter", float64(stats.Frees)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(stats.NumGC)},
+		{"go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter", float64(stats.PauseTotalNs) / 1e9},
+		{"process_start_time_seconds", "Start time of the process since unix epoch in seconds.", "gauge", float64(g.start.UnixNano()) / 1e9},
+	}
+	for _, gauge := range gauges {
+		writeHeader(w, gauge.name, gauge.help, gauge.kind)
+		writeSample(w, gauge.name, nil, gauge.value)
+	}
+	writeHeader(w, "go_info", "Information about the Go environment.", "gauge")
+	writeSample(w, "go_info", []string{"version", runtime.Version()}, 1)
+}
+
+// the number of OS threads the runtime created
+func threads() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
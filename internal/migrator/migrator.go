@@ -0,0 +1,429 @@
+// Filename: internal/migrator/migrator.go
+
+// Package migrator applies the SQL migrations embedded in the binary.
+// The applied versions are kept in the schema_migrations table along
+// with a checksum of their up migration, so that a migration edited
+// after it was applied is noticed. Only one migrator runs at a time,
+// thanks to a PostgreSQL advisory lock.
+package migrator
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// any number will do, as long as nothing else uses it
+const lockID = 7_175_410_261
+
+var ErrChecksumMismatch = errors.New("applied migration has changed")
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// A Migration is a pair of NNNNNN_name.up.sql and NNNNNN_name.down.sql files
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// the state of a migration in the database
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// the checksum recorded when it was applied, if it differs
+	ChangedFrom string
+}
+
+var filename = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load the migrations from the files of fsys, in version order
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := filename.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		switch match[3] {
+		case "up":
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		case "down":
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	return migrations, nil
+}
+
+// A Migrator moves the database between versions
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+func New(db *sql.DB, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// Latest is the version of the newest migration, i.e. the version
+// the database must be at for this build to work
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every migration that hasn't been applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the last steps migrations applied. Like Goto, it
+// refuses to run when a newer build applied migrations this one lacks
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		slices.Sort(versions)
+		target := int64(0)
+		if steps < len(versions) {
+			target = versions[len(versions)-steps-1]
+		}
+		return m.migrate(ctx, conn, applied, target)
+	})
+}
+
+// Goto migrates up or down to version, 0 rolls everything back
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && !slices.ContainsFunc(m.migrations, func(mg Migration) bool { return mg.Version == version }) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		return m.migrate(ctx, conn, applied, version)
+	})
+}
+
+// Status lists every migration and whether it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]appliedMigration) error {
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if a, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = a.appliedAt
+				if a.checksum != migration.Checksum {
+					status.ChangedFrom = a.checksum
+				}
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		// applied by a newer build
+		for version, a := range applied {
+			statuses = append(statuses, Status{
+				Migration: Migration{Version: version, Name: a.name, Checksum: a.checksum},
+				Applied:   true,
+				AppliedAt: a.appliedAt,
+			})
+		}
+		slices.SortFunc(statuses, func(a, b Status) int { return cmp.Compare(a.Version, b.Version) })
+		return nil
+	})
+	return statuses, err
+}
+
+// Check reports an error unless the database is exactly at the
+// migrations of this build. It doesn't take the lock, so it is cheap
+// enough for the readiness check
+func (m *Migrator) Check(ctx context.Context) (version int64, err error) {
+	applied, err := readApplied(ctx, m.db)
+	if err != nil {
+		return 0, err
+	}
+	for v := range applied {
+		version = max(version, v)
+	}
+	for _, migration := range m.migrations {
+		a, ok := applied[migration.Version]
+		switch {
+		case !ok:
+			return version, fmt.Errorf("migration %d (%s) is pending", migration.Version, migration.Name)
+		case a.checksum != migration.Checksum:
+			return version, fmt.Errorf("%w: %d (%s)", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+		delete(applied, migration.Version)
+	}
+	for v := range applied {
+		return version, fmt.Errorf("migration %d was applied by a newer build", v)
+	}
+	return version, nil
+}
+
+// move from the applied migrations to version, one migration at a time
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn,
+	applied map[int64]appliedMigration, version int64) error {
+
+	// a migration this build doesn't have was applied by a newer one,
+	// and only that build knows how to roll it back or what comes next
+	for _, v := range slices.Sorted(maps.Keys(applied)) {
+		known := slices.ContainsFunc(m.migrations, func(mg Migration) bool { return mg.Version == v })
+		if !known {
+			return fmt.Errorf("%w: %d was applied by a newer build", ErrUnknownVersion, v)
+		}
+	}
+
+	// a migration that changed since it was applied may not do what
+	// the ones after it expect, so someone has to look into it first
+	for _, migration := range m.migrations {
+		a, ok := applied[migration.Version]
+		if ok && a.checksum != migration.Checksum {
+			return fmt.Errorf("%w: %d (%s)", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, migration := range slices.Backward(m.migrations) {
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			err := m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run one migration and record it, in a single transaction so that a
+// failed migration leaves nothing behind
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	direction, script := "up", migration.Up
+	if !up {
+		direction, script = "down", migration.Down
+	}
+	m.logger.Info("applying migration", "version", migration.Version, "name", migration.Name,
+		"direction", direction)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if script != "" {
+		_, err = tx.ExecContext(ctx, script)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) %s: %w", migration.Version, migration.Name, direction, err)
+		}
+	}
+	if up {
+		query := `
+		INSERT INTO schema_migrations (version, name, checksum)
+		VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, migration.Version, migration.Name, migration.Checksum)
+	} else {
+		query := `
+		DELETE FROM schema_migrations
+		WHERE version = $1`
+		_, err = tx.ExecContext(ctx, query, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// run fn holding the advisory lock, with the table set up and the
+// applied migrations read
+func (m *Migrator) withLock(ctx context.Context,
+	fn func(conn *sql.Conn, applied map[int64]appliedMigration) error) error {
+
+	// the lock belongs to a session, so everything has to go
+	// through the same connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	err = m.ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	applied, err := readApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+// create the schema_migrations table if it doesn't exist yet
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	// the migrate CLI we used before keeps a single (version, dirty) row
+	var legacy bool
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		AND table_name = 'schema_migrations' AND column_name = 'dirty'
+	)`
+	err := conn.QueryRowContext(ctx, query).Scan(&legacy)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		return m.adoptLegacy(ctx, conn)
+	}
+	_, err = conn.ExecContext(ctx, schemaMigrationsTable)
+	return err
+}
+
+const schemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		checksum text NOT NULL,
+		applied_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+	)`
+
+// take over a database migrated with the migrate CLI: every migration up
+// to its version is recorded as applied, with the checksum we have now
+func (m *Migrator) adoptLegacy(ctx context.Context, conn *sql.Conn) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// this is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var version int64
+	var dirty bool
+	err = tx.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d failed under the migrate CLI, fix the database by hand first", version)
+	}
+	_, err = tx.ExecContext(ctx, `DROP TABLE schema_migrations`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, schemaMigrationsTable)
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		query := `
+		INSERT INTO schema_migrations (version, name, checksum)
+		VALUES ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, migration.Version, migration.Name, migration.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+	m.logger.Info("took over the schema_migrations table of the migrate CLI", "version", version)
+	return tx.Commit()
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func readApplied(ctx context.Context, db querier) (map[int64]appliedMigration, error) {
+	query := `
+	SELECT version, name, checksum, applied_at
+	FROM schema_migrations`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,449 @@
+// Filename: internal/migrator/migrator_test.go
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testMigrations = fstest.MapFS{
+	"000001_create_a.up.sql":   {Data: []byte("CREATE a")},
+	"000001_create_a.down.sql": {Data: []byte("DROP a")},
+	"000002_create_b.up.sql":   {Data: []byte("CREATE b")},
+	"000002_create_b.down.sql": {Data: []byte("DROP b")},
+	"000003_create_c.up.sql":   {Data: []byte("CREATE c")},
+	"000003_create_c.down.sql": {Data: []byte("DROP c")},
+	"README.md":                {Data: []byte("not a migration")},
+}
+
+// a database that understands just the statements of the migrator, and
+// runs the scripts by writing them down
+type fakeDB struct {
+	// held between pg_advisory_lock and pg_advisory_unlock
+	advisory sync.Mutex
+
+	mu      sync.Mutex
+	applied map[int64]appliedMigration
+	// the (version, dirty) row of the migrate CLI, if its table is there
+	legacy *[2]any
+	// the scripts that ran, in order
+	scripts []string
+	// "lock" and "unlock", with the connection they came from
+	locks []string
+	conns int
+}
+
+type fakeConn struct {
+	db       *fakeDB
+	id       int
+	snapshot *fakeDB // the state to go back to if the transaction fails
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.conns++
+	return &fakeConn{db: db, id: db.conns}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver { return nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.snapshot = &fakeDB{
+		applied: maps.Clone(c.db.applied),
+		legacy:  c.db.legacy,
+		scripts: slices.Clone(c.db.scripts),
+	}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.snapshot = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.snapshot != nil {
+		c.db.applied, c.db.legacy, c.db.scripts = c.snapshot.applied, c.snapshot.legacy, c.snapshot.scripts
+		c.snapshot = nil
+	}
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	// outside of mu, since it waits for the other migrators
+	if strings.HasPrefix(query, "SELECT pg_advisory_lock") {
+		c.db.advisory.Lock()
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_lock"):
+		c.db.locks = append(c.db.locks, fmt.Sprintf("lock %d", c.id))
+	case strings.HasPrefix(query, "SELECT pg_advisory_unlock"):
+		c.db.locks = append(c.db.locks, fmt.Sprintf("unlock %d", c.id))
+		c.db.advisory.Unlock()
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+	case query == "DROP TABLE schema_migrations":
+		c.db.legacy = nil
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		c.db.applied[args[0].Value.(int64)] = appliedMigration{
+			name:      args[1].Value.(string),
+			checksum:  args[2].Value.(string),
+			appliedAt: time.Now(),
+		}
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		delete(c.db.applied, args[0].Value.(int64))
+	case strings.Contains(query, "FAIL"):
+		return nil, errors.New("syntax error")
+	default:
+		c.db.scripts = append(c.db.scripts, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	rows := &fakeRows{}
+	switch {
+	case strings.Contains(query, "information_schema.columns"):
+		rows.columns = []string{"exists"}
+		rows.values = [][]driver.Value{{c.db.legacy != nil}}
+	case strings.Contains(query, "SELECT version, dirty"):
+		rows.columns = []string{"version", "dirty"}
+		if c.db.legacy != nil {
+			rows.values = [][]driver.Value{{c.db.legacy[0], c.db.legacy[1]}}
+		}
+	case strings.Contains(query, "SELECT version, name, checksum, applied_at"):
+		rows.columns = []string{"version", "name", "checksum", "applied_at"}
+		for _, version := range slices.Sorted(maps.Keys(c.db.applied)) {
+			a := c.db.applied[version]
+			rows.values = append(rows.values, []driver.Value{version, a.name, a.checksum, a.appliedAt})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// a migrator on a fake database with the given versions applied
+func newTestMigrator(t *testing.T, fsys fs.FS, applied ...int64) (*Migrator, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{applied: map[int64]appliedMigration{}}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	m, err := New(db, fsys, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range applied {
+		fake.applied[version] = appliedMigration{name: fmt.Sprintf("v%d", version), checksum: checksumOf(m, version)}
+	}
+	return m, fake
+}
+
+// the checksum this build has for version, or a made up one
+func checksumOf(m *Migrator, version int64) string {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration.Checksum
+		}
+	}
+	return "unknown"
+}
+
+func (db *fakeDB) versions() []int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return slices.Sorted(maps.Keys(db.applied))
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("got %d migrations, want 3", len(migrations))
+	}
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) || migration.Up == "" || migration.Down == "" || len(migration.Checksum) != 64 {
+			t.Errorf("migration %d = %+v", i, migration)
+		}
+	}
+
+	broken := []fstest.MapFS{
+		{"000001_a.down.sql": {Data: []byte("DROP a")}},
+		{"000001_a.up.sql": {Data: []byte("CREATE a")}, "000001_b.down.sql": {Data: []byte("DROP b")}},
+	}
+	for _, fsys := range broken {
+		_, err := Load(fsys)
+		if err == nil {
+			t.Errorf("Load(%v) didn't fail", slices.Collect(maps.Keys(fsys)))
+		}
+	}
+}
+
+func TestUp(t *testing.T) {
+	m, fake := newTestMigrator(t, testMigrations, 1)
+	err := m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"CREATE b", "CREATE c"}; !slices.Equal(fake.scripts, want) {
+		t.Errorf("scripts = %q, want %q", fake.scripts, want)
+	}
+	if want := []int64{1, 2, 3}; !slices.Equal(fake.versions(), want) {
+		t.Errorf("applied = %v, want %v", fake.versions(), want)
+	}
+	if fake.applied[3].checksum != checksumOf(m, 3) || fake.applied[3].name != "create_c" {
+		t.Errorf("recorded %+v", fake.applied[3])
+	}
+	version, err := m.Check(context.Background())
+	if err != nil || version != 3 {
+		t.Errorf("Check() = %d, %v; want 3, nil", version, err)
+	}
+
+	// nothing left to do
+	fake.scripts = nil
+	err = m.Up(context.Background())
+	if err != nil || len(fake.scripts) != 0 {
+		t.Errorf("second Up ran %q, error %v", fake.scripts, err)
+	}
+}
+
+// a migration that fails leaves nothing behind, and the ones after it
+// don't run
+func TestUpFailure(t *testing.T) {
+	fsys := maps.Clone(testMigrations)
+	fsys["000002_create_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE b; FAIL")}
+	m, fake := newTestMigrator(t, fsys)
+	err := m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (create_b) up") {
+		t.Fatalf("error = %v, want migration 2 to fail", err)
+	}
+	if want := []int64{1}; !slices.Equal(fake.versions(), want) {
+		t.Errorf("applied = %v, want %v", fake.versions(), want)
+	}
+	// the lock was given back
+	if fake.locks[len(fake.locks)-1] != "unlock 1" {
+		t.Errorf("locks = %q", fake.locks)
+	}
+}
+
+// two migrators at once take turns, so every migration runs once
+func TestAdvisoryLock(t *testing.T) {
+	m, fake := newTestMigrator(t, testMigrations)
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.Up(context.Background())
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"CREATE a", "CREATE b", "CREATE c"}; !slices.Equal(fake.scripts, want) {
+		t.Errorf("scripts = %q, want %q", fake.scripts, want)
+	}
+	// each lock is released by the connection that took it, before the
+	// next one is taken
+	if len(fake.locks) != 2*len(errs) {
+		t.Fatalf("locks = %q", fake.locks)
+	}
+	for i := 0; i < len(fake.locks); i += 2 {
+		conn := strings.TrimPrefix(fake.locks[i], "lock ")
+		if fake.locks[i] == conn || fake.locks[i+1] != "unlock "+conn {
+			t.Errorf("locks = %q", fake.locks)
+			break
+		}
+	}
+}
+
+// a migration edited after it was applied stops everything
+func TestChecksumMismatch(t *testing.T) {
+	m, fake := newTestMigrator(t, testMigrations, 1, 2)
+	fake.applied[1] = appliedMigration{name: "create_a", checksum: "edited"}
+
+	err := m.Up(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Up() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	err = m.Goto(context.Background(), 0)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Goto(0) error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if len(fake.scripts) != 0 {
+		t.Errorf("ran %q", fake.scripts)
+	}
+	_, err = m.Check(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Check() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].ChangedFrom != "edited" || statuses[1].ChangedFrom != "" || statuses[2].Applied {
+		t.Errorf("statuses = %+v", statuses)
+	}
+}
+
+// a database migrated with the migrate CLI is taken over without
+// running its migrations again
+func TestLegacy(t *testing.T) {
+	m, fake := newTestMigrator(t, testMigrations)
+	fake.legacy = &[2]any{int64(2), false}
+	err := m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.legacy != nil {
+		t.Error("the table of the migrate CLI is still there")
+	}
+	if want := []string{"CREATE c"}; !slices.Equal(fake.scripts, want) {
+		t.Errorf("scripts = %q, want %q", fake.scripts, want)
+	}
+	for _, version := range []int64{1, 2, 3} {
+		if fake.applied[version].checksum != checksumOf(m, version) {
+			t.Errorf("version %d recorded as %+v", version, fake.applied[version])
+		}
+	}
+
+	// a failed migration has to be fixed by hand
+	m, fake = newTestMigrator(t, testMigrations)
+	fake.legacy = &[2]any{int64(2), true}
+	err = m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "by hand") {
+		t.Errorf("error = %v, want the dirty version to be refused", err)
+	}
+	if fake.legacy == nil || len(fake.applied) != 0 || len(fake.scripts) != 0 {
+		t.Errorf("the database was changed: %v, %v, %q", fake.legacy, fake.applied, fake.scripts)
+	}
+}
+
+func TestDownAndGoto(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(m *Migrator) error
+		want    []int64
+		scripts []string
+	}{
+		{"down one", func(m *Migrator) error { return m.Down(context.Background(), 1) },
+			[]int64{1, 2}, []string{"DROP c"}},
+		{"down two", func(m *Migrator) error { return m.Down(context.Background(), 2) },
+			[]int64{1}, []string{"DROP c", "DROP b"}},
+		{"down more than applied", func(m *Migrator) error { return m.Down(context.Background(), 10) },
+			[]int64{}, []string{"DROP c", "DROP b", "DROP a"}},
+		{"goto an older version", func(m *Migrator) error { return m.Goto(context.Background(), 1) },
+			[]int64{1}, []string{"DROP c", "DROP b"}},
+		{"goto the current version", func(m *Migrator) error { return m.Goto(context.Background(), 3) },
+			[]int64{1, 2, 3}, nil},
+		{"goto 0", func(m *Migrator) error { return m.Goto(context.Background(), 0) },
+			[]int64{}, []string{"DROP c", "DROP b", "DROP a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake := newTestMigrator(t, testMigrations, 1, 2, 3)
+			err := tt.run(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(fake.versions(), tt.want) {
+				t.Errorf("applied = %v, want %v", fake.versions(), tt.want)
+			}
+			if !slices.Equal(fake.scripts, tt.scripts) {
+				t.Errorf("scripts = %q, want %q", fake.scripts, tt.scripts)
+			}
+		})
+	}
+
+	// up again from the middle
+	m, fake := newTestMigrator(t, testMigrations, 1)
+	err := m.Goto(context.Background(), 2)
+	if err != nil || !slices.Equal(fake.scripts, []string{"CREATE b"}) {
+		t.Errorf("Goto(2) ran %q, error %v", fake.scripts, err)
+	}
+	err = m.Goto(context.Background(), 4)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Goto(4) error = %v, want %v", err, ErrUnknownVersion)
+	}
+}
+
+// what a newer build applied can only be rolled back by that build
+func TestUnknownApplied(t *testing.T) {
+	runs := map[string]func(m *Migrator) error{
+		"down": func(m *Migrator) error { return m.Down(context.Background(), 1) },
+		"goto": func(m *Migrator) error { return m.Goto(context.Background(), 2) },
+		"up":   func(m *Migrator) error { return m.Up(context.Background()) },
+	}
+	for name, run := range runs {
+		t.Run(name, func(t *testing.T) {
+			m, fake := newTestMigrator(t, testMigrations, 1, 2, 3, 4)
+			err := run(m)
+			if !errors.Is(err, ErrUnknownVersion) || !strings.Contains(err.Error(), "4") {
+				t.Errorf("error = %v, want %v for 4", err, ErrUnknownVersion)
+			}
+			if want := []int64{1, 2, 3, 4}; !slices.Equal(fake.versions(), want) || len(fake.scripts) != 0 {
+				t.Errorf("applied = %v and ran %q, want nothing changed", fake.versions(), fake.scripts)
+			}
+		})
+	}
+
+	m, _ := newTestMigrator(t, testMigrations, 1, 2, 3, 4)
+	_, err := m.Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "newer build") {
+		t.Errorf("Check() error = %v, want the newer build to be reported", err)
+	}
+	statuses, err := m.Status(context.Background())
+	if err != nil || len(statuses) != 4 || !statuses[3].Applied || statuses[3].Name != "v4" {
+		t.Errorf("Status() = %+v, %v", statuses, err)
+	}
+}
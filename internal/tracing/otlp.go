@@ -0,0 +1,207 @@
+// Filename: internal/tracing/otlp.go
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the most spans sent in one request to the collector
+const maxBatch = 512
+
+// An Exporter sends the ended spans to an OpenTelemetry collector in
+// batches, as OTLP/HTTP with the JSON encoding. Spans are dropped, not
+// queued forever, when the collector can't keep up
+type Exporter struct {
+	endpoint string
+	service  string
+	version  string
+	client   *http.Client
+	logger   *slog.Logger
+	mu       sync.Mutex
+	spans    []*Span
+	dropped  int
+	ready    chan struct{}
+}
+
+// NewExporter sends spans to the collector at endpoint, e.g.
+// http://localhost:4318 (the /v1/traces path is added)
+func NewExporter(endpoint string, service string, version string, logger *slog.Logger) *Exporter {
+	return &Exporter{
+		endpoint: endpoint + "/v1/traces",
+		service:  service,
+		version:  version,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+func (e *Exporter) add(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) >= 4*maxBatch {
+		e.dropped++
+		return
+	}
+	e.spans = append(e.spans, span)
+	// wake the exporter up once there is a full batch
+	if len(e.spans) >= maxBatch {
+		select {
+		case e.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run sends the spans every interval (or sooner if a batch fills up)
+// until ctx is done, then sends what is left
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		case <-e.ready:
+			e.flush()
+		}
+	}
+}
+
+// send everything we have, a batch at a time
+func (e *Exporter) flush() {
+	e.mu.Lock()
+	spans := e.spans
+	dropped := e.dropped
+	e.spans = nil
+	e.dropped = 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		e.logger.Warn("dropped spans, the collector can't keep up", "spans", dropped)
+	}
+	for len(spans) > 0 {
+		batch := spans[:min(len(spans), maxBatch)]
+		spans = spans[len(batch):]
+		err := e.send(batch)
+		if err != nil {
+			e.logger.Error("unable to export spans", "error", err.Error(), "spans", len(batch))
+		}
+	}
+}
+
+func (e *Exporter) send(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// the OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex
+// and 64 bit integers are strings, as the spec asks
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            map[string]any `json:"status,omitempty"`
+}
+
+func (e *Exporter) request(spans []*Span) map[string]any {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           span.context.TraceID.String(),
+			SpanID:            span.context.SpanID.String(),
+			TraceState:        span.context.TraceState,
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.attrs),
+		}
+		if span.parentID != (SpanID{}) {
+			s.ParentSpanID = span.parentID.String()
+		}
+		if span.errorText != "" {
+			// STATUS_CODE_ERROR
+			s.Status = map[string]any{"code": 2, "message": span.errorText}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes([]Attribute{
+					String("service.name", e.service),
+					String("service.version", e.version),
+				}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": e.service},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return kvs
+}
@@ -0,0 +1,147 @@
+// Filename: internal/tracing/otlp_test.go
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// what the stub collector was sent
+type collectorRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+}
+
+// export a span to a stub collector and check what it received
+func TestExporterSendsOTLPJSON(t *testing.T) {
+	received := make(chan collectorRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- collectorRequest{r.Method, r.URL.Path, r.Header.Get("Content-Type"), body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	exporter := NewExporter(collector.URL, "qod", "1.2.3", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tracer := NewTracer(exporter, 1)
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "vendor=value")
+	_, span := tracer.Start(context.Background(), "GET /v1/quotes/:id", KindServer, Extract(header),
+		String("http.request.method", "GET"), Int("http.response.status_code", 200), Bool("cache.hit", true))
+	span.RecordError(errors.New("boom"))
+	span.End()
+
+	// Run sends what is left once it is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exporter.Run(ctx, time.Hour)
+
+	var request collectorRequest
+	select {
+	case request = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the collector received nothing")
+	}
+	if request.method != http.MethodPost || request.path != "/v1/traces" {
+		t.Errorf("got %s %s, want POST /v1/traces", request.method, request.path)
+	}
+	if request.contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", request.contentType)
+	}
+
+	var body struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err := json.Unmarshal(request.body, &body)
+	if err != nil {
+		t.Fatalf("body isn't JSON: %v\n%s", err, request.body)
+	}
+	if len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans) != 1 ||
+		len(body.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("want exactly one span, got\n%s", request.body)
+	}
+	resource := attributeMap(body.ResourceSpans[0].Resource.Attributes)
+	if resource["service.name"] != "qod" || resource["service.version"] != "1.2.3" {
+		t.Errorf("resource attributes = %v", resource)
+	}
+
+	got := body.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	checks := []struct {
+		field, got, want string
+	}{
+		{"traceId", got.TraceID, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"spanId", got.SpanID, span.SpanContext().SpanID.String()},
+		{"parentSpanId", got.ParentSpanID, "00f067aa0ba902b7"},
+		{"traceState", got.TraceState, "vendor=value"},
+		{"name", got.Name, "GET /v1/quotes/:id"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %q, want %q", check.field, check.got, check.want)
+		}
+	}
+	if got.Kind != KindServer {
+		t.Errorf("kind = %d, want %d", got.Kind, KindServer)
+	}
+	if got.StartTimeUnixNano == "" || got.EndTimeUnixNano < got.StartTimeUnixNano {
+		t.Errorf("start = %q, end = %q", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	attributes := attributeMap(got.Attributes)
+	wantAttributes := map[string]any{
+		"http.request.method":       "GET",
+		"http.response.status_code": "200", // 64 bit integers are strings in OTLP JSON
+		"cache.hit":                 true,
+	}
+	for key, want := range wantAttributes {
+		if attributes[key] != want {
+			t.Errorf("attribute %s = %v, want %v", key, attributes[key], want)
+		}
+	}
+	if got.Status["message"] != "boom" || got.Status["code"] != float64(2) {
+		t.Errorf("status = %v, want the error", got.Status)
+	}
+}
+
+// a collector that fails doesn't stop the exporter
+func TestExporterCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := NewExporter(collector.URL, "qod", "1.2.3", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	_, span := NewTracer(exporter, 1).Start(context.Background(), "GET /", KindServer, SpanContext{})
+	span.End()
+	err := exporter.send([]*Span{span})
+	if err == nil {
+		t.Error("want an error when the collector responds with a 503")
+	}
+}
+
+// the values of OTLP attributes by key
+func attributeMap(kvs []otlpKeyValue) map[string]any {
+	attributes := map[string]any{}
+	for _, kv := range kvs {
+		for _, value := range kv.Value {
+			attributes[kv.Key] = value
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,268 @@
+// Filename: internal/tracing/tracing.go
+
+// Package tracing times requests and the work done for them in spans,
+// propagates the W3C Trace Context (traceparent/tracestate) headers and
+// sends the spans to an OpenTelemetry collector over OTLP/HTTP. Like the
+// metrics, it only does what qod needs instead of pulling in the SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is what identifies a span across services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// the kinds of spans, numbered as in OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// an Attribute is a key/value pair describing a span
+type Attribute struct {
+	Key   string
+	Value any // string, int, int64, float64 or bool
+}
+
+func String(key string, value string) Attribute { return Attribute{key, value} }
+func Int(key string, value int) Attribute       { return Attribute{key, int64(value)} }
+func Int64(key string, value int64) Attribute   { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute     { return Attribute{key, value} }
+
+// A Span times one piece of work. All the methods are safe to call on
+// a nil Span, which is what we get when nothing is being traced
+type Span struct {
+	tracer    *Tracer
+	name      string
+	kind      Kind
+	context   SpanContext
+	parentID  SpanID
+	start     time.Time
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []Attribute
+	errorText string
+	ended     bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// rename the span, e.g. once the route of a request is known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// mark the span as failed
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorText = err.Error()
+}
+
+// End the span and hand it to the exporter if it is sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.add(s)
+	}
+}
+
+// A Tracer starts the root spans of the requests
+type Tracer struct {
+	exporter *Exporter
+	// the share of the traces started here that are sampled, from 0 to 1
+	sampleRatio float64
+}
+
+// NewTracer makes a tracer sending its spans to exporter, which may be
+// nil to only propagate the trace context
+func NewTracer(exporter *Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+type contextKey struct{}
+
+// ContextWithSpan returns a copy of ctx holding span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+// SpanFromContext gets the current span back, nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// Start a span for a request. It continues the trace of parent when it
+// is valid (i.e. the client sent a traceparent), otherwise it starts a new one
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, parent SpanContext,
+	attrs ...Attribute) (context.Context, *Span) {
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent.IsValid() {
+		span.context = parent
+		span.parentID = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = t.sample(span.context.TraceID)
+	}
+	rand.Read(span.context.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// the decision is made from the trace ID, so that every service
+// sampling at the same ratio keeps the same traces
+func (t *Tracer) sample(traceID TraceID) bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	}
+	bound := uint64(t.sampleRatio * math.MaxUint64)
+	return binary.BigEndian.Uint64(traceID[8:]) < bound
+}
+
+// Start a span as a child of the span in ctx. When ctx holds no span
+// nothing is traced and the span is nil
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:   parent.tracer,
+		name:     name,
+		kind:     kind,
+		context:  parent.context,
+		parentID: parent.context.SpanID,
+		start:    time.Now(),
+		attrs:    attrs,
+	}
+	rand.Read(span.context.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// Extract the trace context a client sent in the traceparent and
+// tracestate headers. The result isn't valid if there was none (or it
+// was malformed)
+func Extract(h http.Header) SpanContext {
+	sc, ok := parseTraceparent(h.Get("traceparent"))
+	if !ok {
+		return SpanContext{}
+	}
+	sc.TraceState = strings.Join(h.Values("tracestate"), ",")
+	return sc
+}
+
+// Inject the trace context of the span in ctx into the headers
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set("traceparent", formatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	}
+}
+
+// traceparent is version-traceid-spanid-flags, all lowercase hex
+func parseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	var version, flags [1]byte
+	if !decodeHex(version[:], parts[0]) || !decodeHex(sc.TraceID[:], parts[1]) ||
+		!decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+func formatTraceparent(sc SpanContext) string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// decode lowercase hex that exactly fills dst
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
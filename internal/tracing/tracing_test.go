@@ -0,0 +1,141 @@
+// Filename: internal/tracing/tracing_test.go
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		traceparent string
+		tracestate  []string
+		valid       bool
+		sampled     bool
+		traceState  string
+	}{
+		{name: "sampled", traceparent: "00-" + traceID + "-" + spanID + "-01", valid: true, sampled: true},
+		{name: "not sampled", traceparent: "00-" + traceID + "-" + spanID + "-00", valid: true},
+		{name: "other flags", traceparent: "00-" + traceID + "-" + spanID + "-03", valid: true, sampled: true},
+		{name: "surrounding spaces", traceparent: " 00-" + traceID + "-" + spanID + "-01 ", valid: true, sampled: true},
+		{name: "tracestate", traceparent: "00-" + traceID + "-" + spanID + "-01",
+			tracestate: []string{"rojo=00f067aa0ba902b7"}, valid: true, sampled: true, traceState: "rojo=00f067aa0ba902b7"},
+		{name: "tracestate over many headers", traceparent: "00-" + traceID + "-" + spanID + "-01",
+			tracestate: []string{"rojo=1", "congo=2"}, valid: true, sampled: true, traceState: "rojo=1,congo=2"},
+		{name: "future version with more fields", traceparent: "01-" + traceID + "-" + spanID + "-01-what-comes-next",
+			valid: true, sampled: true},
+
+		{name: "missing"},
+		{name: "garbage", traceparent: "hello"},
+		{name: "tracestate without traceparent", tracestate: []string{"rojo=1"}},
+		{name: "invalid version", traceparent: "ff-" + traceID + "-" + spanID + "-01"},
+		{name: "version 00 with more fields", traceparent: "00-" + traceID + "-" + spanID + "-01-extra"},
+		{name: "too few fields", traceparent: "00-" + traceID + "-" + spanID},
+		{name: "uppercase", traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01"},
+		{name: "short trace id", traceparent: "00-4bf92f3577b34da6-" + spanID + "-01"},
+		{name: "long span id", traceparent: "00-" + traceID + "-" + spanID + "00-01"},
+		{name: "not hex", traceparent: "00-" + traceID + "-zzf067aa0ba902b7-01"},
+		{name: "zero trace id", traceparent: "00-00000000000000000000000000000000-" + spanID + "-01"},
+		{name: "zero span id", traceparent: "00-" + traceID + "-0000000000000000-01"},
+		{name: "one digit flags", traceparent: "00-" + traceID + "-" + spanID + "-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.traceparent != "" {
+				header.Set("traceparent", tt.traceparent)
+			}
+			for _, value := range tt.tracestate {
+				header.Add("tracestate", value)
+			}
+			sc := Extract(header)
+			if sc.IsValid() != tt.valid {
+				t.Fatalf("valid = %v, want %v (%+v)", sc.IsValid(), tt.valid, sc)
+			}
+			if !tt.valid {
+				if sc != (SpanContext{}) {
+					t.Errorf("want an empty span context, got %+v", sc)
+				}
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID {
+				t.Errorf("got trace %s span %s", sc.TraceID, sc.SpanID)
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("sampled = %v, want %v", sc.Sampled, tt.sampled)
+			}
+			if sc.TraceState != tt.traceState {
+				t.Errorf("trace state = %q, want %q", sc.TraceState, tt.traceState)
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		tracestate  string
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "rojo=00f067aa0ba902b7"},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := http.Header{}
+			in.Set("traceparent", tt.traceparent)
+			if tt.tracestate != "" {
+				in.Set("tracestate", tt.tracestate)
+			}
+			parent := Extract(in)
+			ctx, span := NewTracer(nil, 1).Start(context.Background(), "GET /", KindServer, parent)
+
+			out := http.Header{}
+			Inject(ctx, out)
+			// the same trace and flags, with our span as the parent
+			want := formatTraceparent(SpanContext{TraceID: parent.TraceID, SpanID: span.SpanContext().SpanID,
+				Sampled: parent.Sampled})
+			if got := out.Get("traceparent"); got != want {
+				t.Errorf("traceparent = %q, want %q", got, want)
+			}
+			if got := out.Get("tracestate"); got != tt.tracestate {
+				t.Errorf("tracestate = %q, want %q", got, tt.tracestate)
+			}
+			if roundTrip := Extract(out); roundTrip.SpanID != span.SpanContext().SpanID {
+				t.Errorf("extracting what was injected gave span %s, want %s", roundTrip.SpanID, span.SpanContext().SpanID)
+			}
+		})
+	}
+
+	// without a span there is nothing to pass on
+	out := http.Header{}
+	Inject(context.Background(), out)
+	if len(out) != 0 {
+		t.Errorf("want no headers, got %v", out)
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	sc := SpanContext{Sampled: true}
+	copy(sc.TraceID[:], []byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36})
+	copy(sc.SpanID[:], []byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7})
+	tests := []struct {
+		sampled bool
+		want    string
+	}{
+		{true, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{false, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+	}
+	for _, tt := range tests {
+		sc.Sampled = tt.sampled
+		if got := formatTraceparent(sc); got != tt.want {
+			t.Errorf("formatTraceparent(sampled=%v) = %q, want %q", tt.sampled, got, tt.want)
+		}
+	}
+}
@@ -2,35 +2,102 @@
 package validator
 
 import (
+	"fmt"
+	"net/url"
 	"regexp"
 	"slices"
+	"strings"
+	"unicode"
 )
 
+// The stable, machine-readable codes of the validation errors.
+// Clients (and translations) should rely on these, not on the messages
+const (
+	CodeInvalid       = "invalid"        // catch-all for one-off checks
+	CodeRequired      = "required"       // the value is missing
+	CodeMinLength     = "min_length"     // params: min
+	CodeMaxLength     = "max_length"     // params: max
+	CodeMinValue      = "min_value"      // params: min
+	CodeMaxValue      = "max_value"      // params: max
+	CodeNotPermitted  = "not_permitted"  // params: permitted
+	CodeInvalidFormat = "invalid_format" // e.g. an email address
+	CodeInvalidURL    = "invalid_url"
+	CodeNotInteger    = "not_integer"
+	CodeNotUnique     = "not_unique" // params: duplicate
+	CodeAlreadyExists = "already_exists"
+)
+
+// Every code above, e.g. to check that they all have a translation
+var Codes = []string{
+	CodeInvalid, CodeRequired, CodeMinLength, CodeMaxLength, CodeMinValue,
+	CodeMaxValue, CodeNotPermitted, CodeInvalidFormat, CodeInvalidURL,
+	CodeNotInteger, CodeNotUnique, CodeAlreadyExists,
+}
+
+// The parameters of a check, e.g. {"max": 100} for max_length
+type Params map[string]any
+
+// A FieldError is one failed check on one field
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Params  Params `json:"params,omitempty"`
+}
+
 // We will create a new type named Validator
 type Validator struct {
-	Errors map[string]string
+	// every error of every field, in the order they were found
+	Errors map[string][]FieldError
+	// the path of the nested object this validator is checking
+	prefix string
 }
 
 // Construct a new Validator and return a pointer to it
 // All validation errors go into this one Validator instance
 func New() *Validator {
 	return &Validator{
-		Errors: make(map[string]string),
+		Errors: make(map[string][]FieldError),
+	}
+}
+
+// Nested returns a Validator for the fields of a nested object. Its
+// errors go into the same map with the keys prefixed by the field, so
+// v.Nested("source").Required("year", ...) reports "source.year"
+func (v *Validator) Nested(field string) *Validator {
+	return &Validator{
+		Errors: v.Errors,
+		prefix: v.path(field),
 	}
 }
 
+// the full path of a field of the object being checked
+func (v *Validator) path(key string) string {
+	if v.prefix == "" {
+		return key
+	}
+	return v.prefix + "." + key
+}
+
 // Let's check  to see if the Validator's map contains any entries
 func (v *Validator) IsEmpty() bool {
 	return len(v.Errors) == 0
 }
 
 // Add a new error entry to the Validator's error map
-// Check first if an entry with the same key does not already exist
+// A field can have several errors, but the same one is only kept once
 func (v *Validator) AddError(key string, message string) {
-	_, exists := v.Errors[key]
-	if !exists {
-		v.Errors[key] = message
+	v.AddFieldError(key, FieldError{Code: CodeInvalid, Message: message})
+}
+
+// Add an error with its code and parameters to the Validator's error map
+func (v *Validator) AddFieldError(key string, fieldError FieldError) {
+	key = v.path(key)
+	for _, existing := range v.Errors[key] {
+		if existing.Code == fieldError.Code && existing.Message == fieldError.Message {
+			return
+		}
 	}
+	v.Errors[key] = append(v.Errors[key], fieldError)
 }
 
 // If any validation check returns false, then we will
@@ -41,10 +108,92 @@ func (v *Validator) Check(acceptable bool, key string, message string) {
 	}
 }
 
+// Same as Check, but with a specific code and parameters
+func (v *Validator) CheckCode(acceptable bool, key string, code string, message string, params Params) {
+	if !acceptable {
+		v.AddFieldError(key, FieldError{Code: code, Message: message, Params: params})
+	}
+}
+
+// The reusable checks. Lengths are counted in characters as a reader
+// would count them (see Length), not in bytes
+
+// Required checks that a string is not empty
+func (v *Validator) Required(key string, value string) {
+	v.CheckCode(value != "", key, CodeRequired, "must be provided", nil)
+}
+
+// MinLength checks that a string has at least min characters
+func (v *Validator) MinLength(key string, value string, min int) {
+	v.CheckCode(Length(value) >= min, key, CodeMinLength,
+		fmt.Sprintf("must be at least %d characters long", min), Params{"min": min})
+}
+
+// MaxLength checks that a string has at most max characters
+func (v *Validator) MaxLength(key string, value string, max int) {
+	v.CheckCode(Length(value) <= max, key, CodeMaxLength,
+		fmt.Sprintf("must not be more than %d characters long", max), Params{"max": max})
+}
+
+// MinValue checks that a number is at least min
+func (v *Validator) MinValue(key string, value int, min int) {
+	v.CheckCode(value >= min, key, CodeMinValue,
+		fmt.Sprintf("must be at least %d", min), Params{"min": min})
+}
+
+// MaxValue checks that a number is at most max
+func (v *Validator) MaxValue(key string, value int, max int) {
+	v.CheckCode(value <= max, key, CodeMaxValue,
+		fmt.Sprintf("must be a maximum of %d", max), Params{"max": max})
+}
+
+// Range checks that a number is between min and max (inclusive)
+func (v *Validator) Range(key string, value int, min int, max int) {
+	v.MinValue(key, value, min)
+	v.MaxValue(key, value, max)
+}
+
+// OneOf checks that a string is one of the permitted values
+func (v *Validator) OneOf(key string, value string, permittedValues ...string) {
+	v.CheckCode(PermittedValue(value, permittedValues...), key, CodeNotPermitted,
+		fmt.Sprintf("must be one of %s", strings.Join(permittedValues, ", ")),
+		Params{"permitted": permittedValues})
+}
+
+// URL checks that a string is an absolute http(s) URL
+func (v *Validator) URL(key string, value string) {
+	v.CheckCode(IsURL(value), key, CodeInvalidURL, "must be a valid URL", nil)
+}
+
+// Unique checks that a list of strings holds no duplicates
+func (v *Validator) Unique(key string, values []string) {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			v.AddFieldError(key, FieldError{
+				Code:    CodeNotUnique,
+				Message: fmt.Sprintf("must not contain duplicate values (%q)", value),
+				Params:  Params{"duplicate": value},
+			})
+			return
+		}
+		seen[value] = true
+	}
+}
+
 func PermittedValue(value string, permittedValues ...string) bool {
 	return slices.Contains(permittedValues, value)
 }
 
+// Check if a string is an absolute http or https URL
+func IsURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // Regex to check if an email is valid
 var EmailRX = regexp.MustCompile(
 	"^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
@@ -53,3 +202,42 @@ var EmailRX = regexp.MustCompile(
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// Length counts the characters of a string the way a reader would,
+// which is closer to Unicode's grapheme clusters than to runes: combining
+// marks, variation selectors, emoji skin tones, tag characters, the
+// vowels and final consonants of decomposed Hangul syllables and anything
+// joined with a zero-width joiner don't start a new character, and a
+// pair of regional indicators is a single flag.
+// It doesn't implement all of UAX #29, but is right for the text we get.
+func Length(s string) int {
+	count := 0
+	joined := false   // the previous rune was a zero-width joiner
+	regional := false // the previous rune started a flag
+	for _, r := range s {
+		switch {
+		case joined:
+			joined = false
+		case r == '\u200D':
+			joined = true
+		case unicode.Is(unicode.M, r),
+			r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+			r >= 0x1F3FB && r <= 0x1F3FF, // emoji modifiers
+			r >= 0xE0020 && r <= 0xE007F, // tags
+			r >= 0x1160 && r <= 0x11FF,   // Hangul vowel and final jamo
+			r >= 0xD7B0 && r <= 0xD7FF:
+		case r >= 0x1F1E6 && r <= 0x1F1FF: // regional indicators
+			if regional {
+				regional = false
+				continue
+			}
+			regional = true
+			count++
+			continue
+		default:
+			count++
+		}
+		regional = false
+	}
+	return count
+}
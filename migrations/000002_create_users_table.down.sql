@@ -1,2 +1,2 @@
--- Filename: migrations/000003_create_users_table.down.sql
+-- Filename: migrations/000002_create_users_table.down.sql
 DROP TABLE IF EXISTS users;
\ No newline at end of file
@@ -1,4 +1,4 @@
--- Filename: migrations/000003_create_users_table.up.sql
+-- Filename: migrations/000002_create_users_table.up.sql
 CREATE TABLE IF NOT EXISTS users (
     id bigserial PRIMARY KEY,
     created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
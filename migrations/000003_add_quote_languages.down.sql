@@ -0,0 +1,6 @@
+-- Filename: migrations/000003_add_quote_languages.down.sql
+DROP INDEX IF EXISTS qod_translation_language_idx;
+DROP INDEX IF EXISTS qod_language_idx;
+DROP INDEX IF EXISTS qod_original_id_idx;
+ALTER TABLE qod DROP COLUMN IF EXISTS original_id;
+ALTER TABLE qod DROP COLUMN IF EXISTS language;
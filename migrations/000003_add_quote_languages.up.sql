@@ -0,0 +1,8 @@
+-- Filename: migrations/000003_add_quote_languages.up.sql
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT 'en';
+-- a translation points to the quote it was translated from
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS original_id bigint REFERENCES qod(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS qod_original_id_idx ON qod (original_id);
+CREATE INDEX IF NOT EXISTS qod_language_idx ON qod (language);
+-- a quote and its translations have at most one version per language
+CREATE UNIQUE INDEX IF NOT EXISTS qod_translation_language_idx ON qod (COALESCE(original_id, id), language);
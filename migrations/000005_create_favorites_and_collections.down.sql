@@ -0,0 +1,4 @@
+-- Filename: migrations/000005_create_favorites_and_collections.down.sql
+DROP TABLE IF EXISTS collection_quotes;
+DROP TABLE IF EXISTS collections;
+DROP TABLE IF EXISTS user_favorites;
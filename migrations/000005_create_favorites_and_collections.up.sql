@@ -0,0 +1,27 @@
+-- Filename: migrations/000005_create_favorites_and_collections.up.sql
+CREATE TABLE IF NOT EXISTS user_favorites (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    quote_id bigint NOT NULL REFERENCES qod ON DELETE CASCADE,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, quote_id)
+);
+
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    public bool NOT NULL DEFAULT false,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT collections_user_name_key UNIQUE (user_id, name)
+);
+
+-- the quotes of a collection, in the order the owner put them
+CREATE TABLE IF NOT EXISTS collection_quotes (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    quote_id bigint NOT NULL REFERENCES qod ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (collection_id, quote_id)
+);
+CREATE INDEX IF NOT EXISTS collection_quotes_position_idx ON collection_quotes (collection_id, position);
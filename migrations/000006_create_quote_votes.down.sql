@@ -0,0 +1,7 @@
+-- Filename: migrations/000006_create_quote_votes.down.sql
+DROP INDEX IF EXISTS qod_votes_idx;
+DROP INDEX IF EXISTS qod_score_idx;
+ALTER TABLE qod DROP COLUMN IF EXISTS score;
+ALTER TABLE qod DROP COLUMN IF EXISTS rating_sum;
+ALTER TABLE qod DROP COLUMN IF EXISTS votes;
+DROP TABLE IF EXISTS quote_votes;
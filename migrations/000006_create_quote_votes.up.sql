@@ -0,0 +1,18 @@
+-- Filename: migrations/000006_create_quote_votes.up.sql
+CREATE TABLE IF NOT EXISTS quote_votes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    quote_id bigint NOT NULL REFERENCES qod ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, quote_id)
+);
+CREATE INDEX IF NOT EXISTS quote_votes_quote_id_idx ON quote_votes (quote_id);
+
+-- the aggregates are kept on the quote so that sorting by them is cheap
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS votes integer NOT NULL DEFAULT 0;
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS rating_sum integer NOT NULL DEFAULT 0;
+-- a quote without votes scores the prior (ratingPrior in internal/data/votes.go)
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS score double precision NOT NULL DEFAULT 3;
+CREATE INDEX IF NOT EXISTS qod_score_idx ON qod (score);
+CREATE INDEX IF NOT EXISTS qod_votes_idx ON qod (votes);
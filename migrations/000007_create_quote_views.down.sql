@@ -0,0 +1,2 @@
+-- Filename: migrations/000007_create_quote_views.down.sql
+DROP TABLE IF EXISTS quote_views_hourly;
@@ -0,0 +1,9 @@
+-- Filename: migrations/000007_create_quote_views.up.sql
+-- views are counted in memory and added to the bucket of their hour
+CREATE TABLE IF NOT EXISTS quote_views_hourly (
+    quote_id bigint NOT NULL REFERENCES qod ON DELETE CASCADE,
+    hour timestamp(0) WITH TIME ZONE NOT NULL,
+    views bigint NOT NULL,
+    PRIMARY KEY (quote_id, hour)
+);
+CREATE INDEX IF NOT EXISTS quote_views_hourly_hour_idx ON quote_views_hourly (hour);
@@ -0,0 +1,8 @@
+-- Filename: migrations/000008_add_quote_moderation.down.sql
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP INDEX IF EXISTS qod_status_idx;
+ALTER TABLE qod DROP COLUMN IF EXISTS rejection_reason;
+ALTER TABLE qod DROP COLUMN IF EXISTS submitted_by;
+ALTER TABLE qod DROP COLUMN IF EXISTS status;
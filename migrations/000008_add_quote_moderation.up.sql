@@ -0,0 +1,34 @@
+-- Filename: migrations/000008_add_quote_moderation.up.sql
+-- the quotes we already have are the curated set
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved'
+    CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS submitted_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE qod ADD COLUMN IF NOT EXISTS rejection_reason text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS qod_status_idx ON qod (status);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES ('quotes:moderate')
+ON CONFLICT DO NOTHING;
+
+-- what the moderators did. There is no foreign key on the quote,
+-- the trail has to outlive the quotes that get deleted
+CREATE TABLE IF NOT EXISTS moderation_log (
+    id bigserial PRIMARY KEY,
+    quote_id bigint NOT NULL,
+    moderator_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS moderation_log_quote_id_idx ON moderation_log (quote_id);
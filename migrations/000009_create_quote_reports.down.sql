@@ -0,0 +1,2 @@
+-- Filename: migrations/000009_create_quote_reports.down.sql
+DROP TABLE IF EXISTS quote_reports;
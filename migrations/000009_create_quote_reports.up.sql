@@ -0,0 +1,16 @@
+-- Filename: migrations/000009_create_quote_reports.up.sql
+CREATE TABLE IF NOT EXISTS quote_reports (
+    id bigserial PRIMARY KEY,
+    quote_id bigint NOT NULL REFERENCES qod ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL
+        CHECK (reason IN ('misattributed', 'offensive', 'duplicate', 'typo', 'other')),
+    note text NOT NULL DEFAULT '',
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) WITH TIME ZONE,
+    resolved_by bigint REFERENCES users ON DELETE SET NULL,
+    resolution text NOT NULL DEFAULT ''
+);
+-- a user can only have one open report on a quote
+CREATE UNIQUE INDEX IF NOT EXISTS quote_reports_open_idx ON quote_reports (quote_id, user_id)
+    WHERE resolved_at IS NULL;
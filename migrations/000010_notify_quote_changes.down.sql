@@ -0,0 +1,3 @@
+-- Filename: migrations/000010_notify_quote_changes.down.sql
+DROP TRIGGER IF EXISTS qod_notify_change ON qod;
+DROP FUNCTION IF EXISTS notify_quote_change();
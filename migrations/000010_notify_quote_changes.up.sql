@@ -0,0 +1,13 @@
+-- Filename: migrations/000010_notify_quote_changes.up.sql
+-- tell every API process which quote changed, so they can drop it from
+-- their caches. The payload is the id of the quote
+CREATE OR REPLACE FUNCTION notify_quote_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('qod_changes', COALESCE(NEW.id, OLD.id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER qod_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON qod
+    FOR EACH ROW EXECUTE FUNCTION notify_quote_change();
@@ -0,0 +1,10 @@
+// Filename: migrations/migrations.go
+
+// Package migrations embeds the SQL migrations in the binary, so it can
+// migrate the database itself (see internal/migrator)
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS